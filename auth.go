@@ -2,7 +2,13 @@ package wire
 
 import (
 	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
 	"errors"
+	"fmt"
+	"strings"
 
 	"github.com/jeroenrinzema/psql-wire/codes"
 	pgerror "github.com/jeroenrinzema/psql-wire/errors"
@@ -20,6 +26,17 @@ const (
 	// authClearTextPassword is a authentication type used to tell the client to identify
 	// itself by sending the password in clear text to the Postgres server.
 	authClearTextPassword authType = 3
+	// authMD5Password is a authentication type used to tell the client to identify
+	// itself by sending a salted MD5 hash of its password to the Postgres server.
+	authMD5Password authType = 5
+	// authSASL indicates that the client has to start a SASL authentication
+	// exchange using one of the advertised mechanisms.
+	authSASL authType = 10
+	// authSASLContinue contains a SASL challenge which has to be answered by
+	// the client.
+	authSASLContinue authType = 11
+	// authSASLFinal contains the additional data of a completed SASL exchange.
+	authSASLFinal authType = 12
 )
 
 // AuthStrategy represents an authentication strategy used to authenticate a user.
@@ -27,7 +44,18 @@ type AuthStrategy func(ctx context.Context, writer *buffer.Writer, reader *buffe
 
 // BackendKeyDataFunc represents a function that generates backend key data for query cancellation.
 // It should return a process ID and secret key that can be used by clients to cancel queries.
-type BackendKeyDataFunc func(ctx context.Context) (processID int32, secretKey int32)
+// Connections using protocol version 3.2 or later accept secret keys of up to
+// 256 bytes, older protocol versions require a secret key of exactly 4 bytes.
+// The negotiated protocol version could be obtained using [ProtocolVersion].
+type BackendKeyDataFunc func(ctx context.Context) (processID int32, secretKey []byte)
+
+// MaxSecretKeyLength is the maximum length of a secret key used to cancel
+// queries as defined by protocol version 3.2.
+const MaxSecretKeyLength = 256
+
+// legacySecretKeyLength is the secret key length used by protocol versions
+// prior to 3.2.
+const legacySecretKeyLength = 4
 
 // handleAuth handles the client authentication for the given connection.
 // This methods validates the incoming credentials and writes to the client whether
@@ -36,13 +64,14 @@ type BackendKeyDataFunc func(ctx context.Context) (processID int32, secretKey in
 func (srv *Server) handleAuth(ctx context.Context, reader *buffer.Reader, writer *buffer.Writer) (context.Context, error) {
 	srv.logger.Debug("authenticating client connection")
 
-	if srv.Auth == nil {
+	auth := srv.authStrategy(ctx)
+	if auth == nil {
 		// No authentication strategy configured.
 		// Announcing to the client that the connection is authenticated
 		return ctx, writeAuthType(writer, authOK)
 	}
 
-	return srv.Auth(ctx, writer, reader)
+	return auth(ctx, writer, reader)
 }
 
 // ClearTextPassword announces to the client to authenticate by sending a
@@ -78,17 +107,117 @@ func ClearTextPassword(validate func(ctx context.Context, database, username, pa
 
 		if !valid {
 			authErr := pgerror.WithSeverity(pgerror.WithCode(errors.New("invalid username/password"), codes.InvalidPassword), pgerror.LevelFatal)
-			err = WriteUnterminatedError(writer, authErr)
-			if err != nil {
-				return ctx, err
-			}
-			return ctx, authErr
+			return ctx, writeAuthError(writer, authErr)
 		}
 
 		return ctx, writeAuthType(writer, authOK)
 	}
 }
 
+// md5Prefix is the prefix of MD5 hashed passwords as stored by PostgreSQL.
+const md5Prefix = "md5"
+
+// MD5Password announces to the client to authenticate by sending a salted MD5
+// hash of its password. The given lookup function is called to retrieve the
+// password of the user provided inside the client parameters. The password
+// could either be returned in plain text or as a PostgreSQL MD5 hash
+// ("md5" followed by md5(password+username), see [MD5PasswordHash]). An empty
+// password indicates that the user is unknown. If the provided credentials are
+// invalid or any unexpected error occurs, an error returned and the connection
+// should be closed.
+//
+// NOTE: MD5 authentication is deprecated by PostgreSQL and should only be used
+// to support legacy clients. Prefer [ScramSHA256] whenever possible.
+func MD5Password(lookup func(ctx context.Context, database, username string) (_ context.Context, password string, err error)) AuthStrategy {
+	return func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (_ context.Context, err error) {
+		salt := make([]byte, 4)
+		rand.Read(salt) //nolint:errcheck
+
+		writer.Start(types.ServerAuth)
+		writer.AddInt32(int32(authMD5Password))
+		writer.AddBytes(salt)
+		err = writer.End()
+		if err != nil {
+			return ctx, err
+		}
+
+		params := ClientParameters(ctx)
+		t, _, err := reader.ReadTypedMsg()
+		if err != nil {
+			return ctx, err
+		}
+
+		if t != types.ClientPassword {
+			return ctx, errors.New("unexpected password message")
+		}
+
+		response, err := reader.GetString()
+		if err != nil {
+			return ctx, err
+		}
+
+		username := params[ParamUsername]
+		ctx, password, err := lookup(ctx, params[ParamDatabase], username)
+		if err != nil {
+			return ctx, err
+		}
+
+		// NOTE: the response is compared even if the user is unknown to avoid
+		// exposing the existence of users through timing differences.
+		hash := password
+		if !isMD5PasswordHash(hash) {
+			hash = MD5PasswordHash(username, password)
+		}
+
+		expected := md5Prefix + md5Hex([]byte(hash[len(md5Prefix):]), salt)
+		if subtle.ConstantTimeCompare([]byte(response), []byte(expected)) != 1 || password == "" {
+			authErr := pgerror.WithSeverity(pgerror.WithCode(errors.New("invalid username/password"), codes.InvalidPassword), pgerror.LevelFatal)
+			return ctx, writeAuthError(writer, authErr)
+		}
+
+		return ctx, writeAuthType(writer, authOK)
+	}
+}
+
+// MD5PasswordHash returns the PostgreSQL MD5 hash of the given username and
+// password as stored inside pg_authid: "md5" followed by md5(password+username).
+func MD5PasswordHash(username, password string) string {
+	return md5Prefix + md5Hex([]byte(password), []byte(username))
+}
+
+// isMD5PasswordHash checks whether the given password is a PostgreSQL MD5 hash.
+func isMD5PasswordHash(password string) bool {
+	hash, ok := strings.CutPrefix(password, md5Prefix)
+	if !ok || len(hash) != hex.EncodedLen(md5.Size) {
+		return false
+	}
+
+	_, err := hex.DecodeString(hash)
+	return err == nil
+}
+
+// md5Hex returns the hex encoded MD5 hash of the given values.
+func md5Hex(values ...[]byte) string {
+	hash := md5.New()
+	for _, value := range values {
+		hash.Write(value)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// writeAuthError writes the given authentication error to the client. The
+// given error is returned once written to indicate that the connection should
+// be closed. Any error thrown while writing the error is returned instead.
+func writeAuthError(writer *buffer.Writer, err error) error {
+	werr := WriteUnterminatedError(writer, err)
+	if werr != nil {
+		return werr
+	}
+
+	return err
+}
+
 // writeAuthType writes the auth type to the client informing the client about the
 // authentication status and the expected data to be received.
 func writeAuthType(writer *buffer.Writer, status authType) error {
@@ -97,24 +226,62 @@ func writeAuthType(writer *buffer.Writer, status authType) error {
 	return writer.End()
 }
 
+// writeAuthData writes the auth type followed by the given authentication data
+// to the client. This is used to exchange SASL messages with the client.
+func writeAuthData(writer *buffer.Writer, status authType, data []byte) error {
+	writer.Start(types.ServerAuth)
+	writer.AddInt32(int32(status))
+	writer.AddBytes(data)
+	return writer.End()
+}
+
 // writeBackendKeyData writes the backend key data to the client. This message contains
 // cancellation key data that the frontend must save if it wishes to be able to issue
 // CancelRequest messages later.
-func writeBackendKeyData(writer *buffer.Writer, processID int32, secretKey int32) error {
+func writeBackendKeyData(writer *buffer.Writer, version types.Version, processID int32, secretKey []byte) error {
+	err := validateSecretKey(version, secretKey)
+	if err != nil {
+		return err
+	}
+
 	writer.Start(types.ServerBackendKeyData)
 	writer.AddInt32(processID)
-	writer.AddInt32(secretKey)
+	writer.AddBytes(secretKey)
 	return writer.End()
 }
 
-// IsSuperUser checks whether the given connection context is a super user.
+// validateSecretKey checks whether the given secret key could be used by
+// clients speaking the given protocol version.
+func validateSecretKey(version types.Version, secretKey []byte) error {
+	if version < types.Version32 {
+		if len(secretKey) != legacySecretKeyLength {
+			return fmt.Errorf("invalid secret key length %d: protocol %d.%d requires a secret key of %d bytes", len(secretKey), version.Major(), version.Minor(), legacySecretKeyLength)
+		}
+
+		return nil
+	}
+
+	if len(secretKey) < legacySecretKeyLength || len(secretKey) > MaxSecretKeyLength {
+		return fmt.Errorf("invalid secret key length %d: expected between %d and %d bytes", len(secretKey), legacySecretKeyLength, MaxSecretKeyLength)
+	}
+
+	return nil
+}
+
+// IsSuperUser checks whether the current role of the given connection context
+// is a super user.
 func IsSuperUser(ctx context.Context) bool {
-	return false
+	role := CurrentRole(ctx)
+	return role != nil && role.Superuser
 }
 
 // AuthenticatedUsername returns the username of the authenticated user of the
 // given connection context.
 func AuthenticatedUsername(ctx context.Context) string {
+	if role := AuthenticatedRole(ctx); role != nil {
+		return role.Name
+	}
+
 	parameters := ClientParameters(ctx)
 	return parameters[ParamUsername]
 }
@@ -0,0 +1,87 @@
+package wire
+
+import (
+	"context"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+)
+
+// CertificateMapper maps the verified client certificate to the user provided
+// inside the client parameters. The returned context is used for the remainder
+// of the connection. False is returned when the certificate does not identify
+// the given user.
+type CertificateMapper func(ctx context.Context, cert *x509.Certificate, user string) (context.Context, bool, error)
+
+// ClientCertificate authenticates the client using the TLS client certificate
+// presented during the TLS handshake, equivalent to the pg_hba.conf cert
+// method. The leaf certificate of the verified chain is passed to the given
+// mapper to validate whether it identifies the user provided inside the client
+// parameters. The server TLS config has to verify client certificates
+// (ex: tls.RequireAndVerifyClientCert together with ClientCAs), unverified
+// certificates are rejected. If the certificate does not identify the user or
+// any unexpected error occurs, an error returned and the connection should be
+// closed.
+func ClientCertificate(mapper CertificateMapper) AuthStrategy {
+	return func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (_ context.Context, err error) {
+		username := ClientParameters(ctx)[ParamUsername]
+
+		chain := PeerCertificates(ctx)
+		if len(chain) == 0 {
+			authErr := pgerror.WithSeverity(pgerror.WithCode(errors.New("connection requires a valid client certificate"), codes.InvalidAuthorizationSpecification), pgerror.LevelFatal)
+			return ctx, writeAuthError(writer, authErr)
+		}
+
+		ctx, valid, err := mapper(ctx, chain[0], username)
+		if err != nil {
+			return ctx, err
+		}
+
+		if !valid {
+			authErr := fmt.Errorf("certificate authentication failed for user %q", username)
+			authErr = pgerror.WithSeverity(pgerror.WithCode(authErr, codes.InvalidAuthorizationSpecification), pgerror.LevelFatal)
+			return ctx, writeAuthError(writer, authErr)
+		}
+
+		return ctx, writeAuthType(writer, authOK)
+	}
+}
+
+// MatchCertificateCN is a [CertificateMapper] which accepts certificates whose
+// subject common name matches the given user.
+func MatchCertificateCN(ctx context.Context, cert *x509.Certificate, user string) (context.Context, bool, error) {
+	return ctx, user != "" && cert.Subject.CommonName == user, nil
+}
+
+// MatchCertificateSAN is a [CertificateMapper] which accepts certificates
+// containing a subject alternative name (DNS name, email address or URI)
+// matching the given user.
+func MatchCertificateSAN(ctx context.Context, cert *x509.Certificate, user string) (context.Context, bool, error) {
+	if user == "" {
+		return ctx, false, nil
+	}
+
+	for _, name := range cert.DNSNames {
+		if name == user {
+			return ctx, true, nil
+		}
+	}
+
+	for _, address := range cert.EmailAddresses {
+		if address == user {
+			return ctx, true, nil
+		}
+	}
+
+	for _, uri := range cert.URIs {
+		if uri.String() == user {
+			return ctx, true, nil
+		}
+	}
+
+	return ctx, false, nil
+}
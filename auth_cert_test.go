@@ -0,0 +1,161 @@
+package wire
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// generateTestClientCert generates a new certificate authority and a client
+// certificate signed by the authority using the given template.
+func generateTestClientCert(t *testing.T, template *x509.Certificate) (*x509.CertPool, tls.Certificate) {
+	t.Helper()
+
+	caKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	ca := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	caDER, err := x509.CreateCertificate(rand.Reader, ca, ca, &caKey.PublicKey, caKey)
+	require.NoError(t, err)
+
+	ca, err = x509.ParseCertificate(caDER)
+	require.NoError(t, err)
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	template.SerialNumber = big.NewInt(2)
+	template.NotBefore = time.Now()
+	template.NotAfter = time.Now().Add(time.Hour)
+	template.KeyUsage = x509.KeyUsageDigitalSignature
+	template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}
+
+	certDER, err := x509.CreateCertificate(rand.Reader, template, ca, &key.PublicKey, caKey)
+	require.NoError(t, err)
+
+	pool := x509.NewCertPool()
+	pool.AddCert(ca)
+
+	return pool, tls.Certificate{Certificate: [][]byte{certDER}, PrivateKey: key}
+}
+
+func TestClientCertificate(t *testing.T) {
+	t.Parallel()
+
+	serverCert, err := generateTestCert()
+	require.NoError(t, err)
+
+	uri, err := url.Parse("spiffe://example.com/admin")
+	require.NoError(t, err)
+
+	pool, clientCert := generateTestClientCert(t, &x509.Certificate{
+		Subject:        pkix.Name{CommonName: "admin"},
+		EmailAddresses: []string{"admin@example.com"},
+		URIs:           []*url.URL{uri},
+	})
+
+	tests := map[string]struct {
+		mapper CertificateMapper
+		user   string
+		cert   bool
+		err    string
+	}{
+		"common name": {
+			mapper: MatchCertificateCN,
+			user:   "admin",
+			cert:   true,
+		},
+		"common name mismatch": {
+			mapper: MatchCertificateCN,
+			user:   "other",
+			cert:   true,
+			err:    "certificate authentication failed",
+		},
+		"subject alternative name": {
+			mapper: MatchCertificateSAN,
+			user:   "admin@example.com",
+			cert:   true,
+		},
+		"subject alternative name uri": {
+			mapper: MatchCertificateSAN,
+			user:   "spiffe://example.com/admin",
+			cert:   true,
+		},
+		"subject alternative name mismatch": {
+			mapper: MatchCertificateSAN,
+			user:   "admin",
+			cert:   true,
+			err:    "certificate authentication failed",
+		},
+		"missing certificate": {
+			mapper: MatchCertificateCN,
+			user:   "admin",
+			err:    "connection requires a valid client certificate",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			tlsConfig := &tls.Config{
+				Certificates: []tls.Certificate{serverCert},
+				ClientAuth:   tls.VerifyClientCertIfGiven,
+				ClientCAs:    pool,
+			}
+
+			mapper := func(ctx context.Context, cert *x509.Certificate, user string) (context.Context, bool, error) {
+				assert.Len(t, PeerCertificates(ctx), 2)
+				return test.mapper(ctx, cert, user)
+			}
+
+			server, err := NewServer(nil, Logger(slogt.New(t)), TLSConfig(tlsConfig), SessionAuthStrategy(ClientCertificate(mapper)))
+			require.NoError(t, err)
+
+			address := TListenAndServe(t, server)
+
+			ctx := context.Background()
+			config, err := pgx.ParseConfig(fmt.Sprintf("postgres://%s:%d?sslmode=require", address.IP, address.Port))
+			require.NoError(t, err)
+
+			config.User = test.user
+
+			if test.cert {
+				config.TLSConfig.Certificates = []tls.Certificate{clientCert}
+			}
+
+			conn, err := pgx.ConnectConfig(ctx, config)
+			if test.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NoError(t, conn.Close(ctx))
+		})
+	}
+}
@@ -0,0 +1,383 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+)
+
+// Default values used by [LimitAuth] whenever the corresponding configuration
+// value has not been set.
+const (
+	DefaultAuthBaseDelay       = 100 * time.Millisecond
+	DefaultAuthMaxDelay        = 5 * time.Second
+	DefaultAuthMaxFailures     = 10
+	DefaultAuthLockoutDuration = 15 * time.Minute
+)
+
+// AuthLimitConfig represents the configuration of the brute-force protection
+// applied by [LimitAuth]. Zero values are replaced with their defaults.
+type AuthLimitConfig struct {
+	// BaseDelay is the delay applied before an authentication attempt after
+	// a single failed attempt. The delay is doubled for every consecutive
+	// failed attempt.
+	BaseDelay time.Duration
+	// MaxDelay is the maximum delay applied before an authentication attempt.
+	MaxDelay time.Duration
+	// MaxFailures is the number of consecutive failed attempts after which
+	// the user or remote address is temporarily locked out.
+	MaxFailures int
+	// LockoutDuration is the duration of a lockout. Failed attempts are
+	// forgotten once no failures have occurred for this duration.
+	LockoutDuration time.Duration
+	// OnEvent is called for every authentication attempt including attempts
+	// rejected due to a lockout.
+	OnEvent func(ctx context.Context, event AuthEvent)
+}
+
+// AuthEventType represents the outcome of an authentication attempt.
+type AuthEventType string
+
+const (
+	// AuthSucceeded indicates that the client has been authenticated.
+	AuthSucceeded AuthEventType = "success"
+	// AuthFailed indicates that the client provided invalid credentials.
+	AuthFailed AuthEventType = "failure"
+	// AuthLocked indicates that the attempt has been rejected since the user
+	// or remote address has been locked out or has too many attempts in
+	// progress.
+	AuthLocked AuthEventType = "locked"
+	// AuthAborted indicates that the attempt ended without the client being
+	// authenticated or rejected (ex: the client closed the connection or the
+	// authentication timeout expired).
+	AuthAborted AuthEventType = "aborted"
+)
+
+// AuthEvent represents a single authentication attempt reported by
+// [LimitAuth]. Events implement [slog.LogValuer] allowing them to be logged
+// as structured data.
+type AuthEvent struct {
+	Type       AuthEventType
+	Time       time.Time
+	Username   string
+	Database   string
+	RemoteAddr net.Addr
+	// Failures contains the number of consecutive failed attempts of the user
+	// or remote address, whichever is higher, including the current attempt.
+	Failures int
+	// Delay is the delay applied before the attempt.
+	Delay time.Duration
+	// LockedUntil is set when the user or remote address is locked out.
+	LockedUntil time.Time
+	Err         error
+}
+
+// LogValue returns the event as a structured log value.
+func (event AuthEvent) LogValue() slog.Value {
+	attrs := []slog.Attr{
+		slog.String("type", string(event.Type)),
+		slog.Time("time", event.Time),
+		slog.String("user", event.Username),
+		slog.String("database", event.Database),
+		slog.Int("failures", event.Failures),
+		slog.Duration("delay", event.Delay),
+	}
+
+	if event.RemoteAddr != nil {
+		attrs = append(attrs, slog.String("remote_addr", event.RemoteAddr.String()))
+	}
+
+	if !event.LockedUntil.IsZero() {
+		attrs = append(attrs, slog.Time("locked_until", event.LockedUntil))
+	}
+
+	if event.Err != nil {
+		attrs = append(attrs, slog.String("err", event.Err.Error()))
+	}
+
+	return slog.GroupValue(attrs...)
+}
+
+// LimitAuth wraps the given authentication strategy with brute-force
+// protection. Failed attempts are tracked per user and per remote address.
+// Consecutive failures result in an exponentially growing delay before the
+// next attempt is made. Once the maximum number of failures has been reached
+// the user or remote address is locked out for the configured duration and
+// attempts are rejected without consulting the given strategy. A successful
+// attempt resets the failures of the user and remote address. Only errors
+// carrying an invalid authorization SQLSTATE (class 28) are counted as
+// failures. Concurrent attempts of a user or remote address which already has
+// failed attempts are limited to the remaining number of failures before a
+// lockout, preventing concurrent attempts from bypassing the lockout. The
+// delay is aborted once the given context is cancelled (ex: the authentication timeout
+// expired).
+//
+// NOTE: locking out users allows any client to temporarily deny access to a
+// known user. Consider the lockout duration carefully.
+func LimitAuth(config AuthLimitConfig, strategy AuthStrategy) AuthStrategy {
+	if config.BaseDelay == 0 {
+		config.BaseDelay = DefaultAuthBaseDelay
+	}
+
+	if config.MaxDelay == 0 {
+		config.MaxDelay = DefaultAuthMaxDelay
+	}
+
+	if config.MaxFailures == 0 {
+		config.MaxFailures = DefaultAuthMaxFailures
+	}
+
+	if config.LockoutDuration == 0 {
+		config.LockoutDuration = DefaultAuthLockoutDuration
+	}
+
+	limiter := &authLimiter{
+		config:   config,
+		attempts: make(map[string]*authAttempts),
+	}
+
+	return func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (_ context.Context, err error) {
+		params := ClientParameters(ctx)
+		event := AuthEvent{
+			Username:   params[ParamUsername],
+			Database:   params[ParamDatabase],
+			RemoteAddr: RemoteAddress(ctx),
+		}
+
+		keys := limiter.keys(event.Username, event.RemoteAddr)
+		var reserved bool
+		event.Failures, event.Delay, event.LockedUntil, reserved = limiter.check(time.Now(), keys)
+
+		defer func() {
+			event.Time = time.Now()
+			event.Err = err
+			if config.OnEvent != nil {
+				config.OnEvent(ctx, event)
+			}
+		}()
+
+		if !reserved {
+			event.Type = AuthLocked
+			authErr := pgerror.WithSeverity(pgerror.WithCode(errors.New("too many failed authentication attempts, try again later"), codes.InvalidAuthorizationSpecification), pgerror.LevelFatal)
+			return ctx, writeAuthError(writer, authErr)
+		}
+
+		if event.Delay > 0 {
+			timer := time.NewTimer(event.Delay)
+			select {
+			case <-timer.C:
+			case <-ctx.Done():
+				timer.Stop()
+				event.Type = AuthAborted
+				limiter.release(keys)
+				return ctx, context.Cause(ctx)
+			}
+		}
+
+		ctx, err = strategy(ctx, writer, reader)
+		switch {
+		case err == nil:
+			event.Type = AuthSucceeded
+			event.Failures = 0
+			limiter.reset(keys)
+		case isAuthFailure(err):
+			event.Type = AuthFailed
+			event.Failures, event.LockedUntil = limiter.fail(time.Now(), keys)
+		default:
+			event.Type = AuthAborted
+			limiter.release(keys)
+		}
+
+		return ctx, err
+	}
+}
+
+// isAuthFailure checks whether the given error indicates that the client
+// provided invalid credentials.
+func isAuthFailure(err error) bool {
+	return strings.HasPrefix(string(pgerror.GetCode(err)), "28")
+}
+
+// authAttempts tracks the failed attempts of a single user or remote address.
+// Attempts in progress are tracked as pending until they have been settled.
+type authAttempts struct {
+	failures    int
+	pending     int
+	last        time.Time
+	lockedUntil time.Time
+}
+
+// authLimiter tracks failed authentication attempts.
+type authLimiter struct {
+	config   AuthLimitConfig
+	mu       sync.Mutex
+	attempts map[string]*authAttempts
+	swept    time.Time
+}
+
+// keys returns the keys used to track attempts of the given user and remote
+// address. The port of the remote address is ignored.
+func (limiter *authLimiter) keys(username string, addr net.Addr) []string {
+	keys := []string{"user:" + username}
+	if ip := remoteIP(addr); ip != nil {
+		keys = append(keys, "addr:"+ip.String())
+	}
+
+	return keys
+}
+
+// stale checks whether the failures of the given attempts could be forgotten.
+func (limiter *authLimiter) stale(now time.Time, attempts *authAttempts) bool {
+	return now.After(attempts.lockedUntil) && now.Sub(attempts.last) > limiter.config.LockoutDuration
+}
+
+// expired checks whether the given attempts could be forgotten.
+func (limiter *authLimiter) expired(now time.Time, attempts *authAttempts) bool {
+	return attempts.pending == 0 && limiter.stale(now, attempts)
+}
+
+// check returns the highest number of consecutive failures of the given keys
+// together with the delay which should be applied before the next attempt.
+// An attempt is reserved for the given keys unless any of the keys is locked
+// out or has failed attempts and reached the maximum number of failures when
+// counting pending attempts as failures. The lockout expiry is returned if any of the keys is locked out. A reserved
+// attempt has to be settled using fail, reset or release.
+func (limiter *authLimiter) check(now time.Time, keys []string) (failures int, delay time.Duration, lockedUntil time.Time, reserved bool) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	var saturated bool
+	for _, key := range keys {
+		attempts, has := limiter.attempts[key]
+		if !has {
+			continue
+		}
+
+		if limiter.stale(now, attempts) {
+			attempts.failures = 0
+			attempts.lockedUntil = time.Time{}
+			if attempts.pending == 0 {
+				delete(limiter.attempts, key)
+				continue
+			}
+		}
+
+		if now.Before(attempts.lockedUntil) && attempts.lockedUntil.After(lockedUntil) {
+			lockedUntil = attempts.lockedUntil
+		}
+
+		failures = max(failures, attempts.failures)
+		if attempts.failures > 0 && attempts.failures+attempts.pending >= limiter.config.MaxFailures {
+			saturated = true
+		}
+	}
+
+	if !lockedUntil.IsZero() || saturated {
+		return failures, 0, lockedUntil, false
+	}
+
+	for _, key := range keys {
+		attempts, has := limiter.attempts[key]
+		if !has {
+			attempts = &authAttempts{}
+			limiter.attempts[key] = attempts
+		}
+
+		attempts.pending++
+	}
+
+	if failures == 0 {
+		return failures, 0, lockedUntil, true
+	}
+
+	delay = limiter.config.MaxDelay
+	if shift := failures - 1; shift < 32 {
+		delay = min(limiter.config.BaseDelay<<shift, limiter.config.MaxDelay)
+	}
+
+	return failures, delay, lockedUntil, true
+}
+
+// fail settles a reserved attempt as failed for the given keys and returns the
+// highest number of consecutive failures together with the lockout expiry if
+// any of the keys has been locked out.
+func (limiter *authLimiter) fail(now time.Time, keys []string) (failures int, lockedUntil time.Time) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	limiter.sweep(now)
+
+	for _, key := range keys {
+		attempts := limiter.attempts[key]
+		attempts.pending--
+		if limiter.stale(now, attempts) {
+			attempts.failures = 0
+		}
+
+		attempts.failures++
+		attempts.last = now
+		if attempts.failures >= limiter.config.MaxFailures {
+			attempts.lockedUntil = now.Add(limiter.config.LockoutDuration)
+			lockedUntil = attempts.lockedUntil
+		}
+
+		failures = max(failures, attempts.failures)
+	}
+
+	return failures, lockedUntil
+}
+
+// reset settles a reserved attempt as succeeded and forgets the failed
+// attempts of the given keys.
+func (limiter *authLimiter) reset(keys []string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	for _, key := range keys {
+		attempts := limiter.attempts[key]
+		attempts.pending--
+		attempts.failures = 0
+		attempts.lockedUntil = time.Time{}
+		if attempts.pending == 0 {
+			delete(limiter.attempts, key)
+		}
+	}
+}
+
+// release settles a reserved attempt which has neither succeeded nor failed
+// for the given keys.
+func (limiter *authLimiter) release(keys []string) {
+	limiter.mu.Lock()
+	defer limiter.mu.Unlock()
+
+	for _, key := range keys {
+		attempts := limiter.attempts[key]
+		attempts.pending--
+		if attempts.pending == 0 && attempts.failures == 0 {
+			delete(limiter.attempts, key)
+		}
+	}
+}
+
+// sweep removes all expired attempts. Attempts are swept at most once per
+// lockout duration to bound the amount of memory used by the limiter.
+func (limiter *authLimiter) sweep(now time.Time) {
+	if now.Sub(limiter.swept) < limiter.config.LockoutDuration {
+		return
+	}
+
+	limiter.swept = now
+	for key, attempts := range limiter.attempts {
+		if limiter.expired(now, attempts) {
+			delete(limiter.attempts, key)
+		}
+	}
+}
@@ -0,0 +1,378 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jeroenrinzema/psql-wire/pkg/mock"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAuthLimiter(t *testing.T) {
+	t.Parallel()
+
+	limiter := &authLimiter{
+		config: AuthLimitConfig{
+			BaseDelay:       time.Second,
+			MaxDelay:        3 * time.Second,
+			MaxFailures:     4,
+			LockoutDuration: time.Minute,
+		},
+		attempts: make(map[string]*authAttempts),
+	}
+
+	now := time.Now()
+	keys := limiter.keys("admin", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234})
+	assert.Equal(t, []string{"user:admin", "addr:127.0.0.1"}, keys)
+
+	failures, delay, locked, reserved := limiter.check(now, keys)
+	assert.Zero(t, failures)
+	assert.Zero(t, delay)
+	assert.True(t, locked.IsZero())
+	assert.True(t, reserved)
+
+	expected := []time.Duration{time.Second, 2 * time.Second, 3 * time.Second}
+	for index, duration := range expected {
+		failures, locked = limiter.fail(now, keys)
+		assert.Equal(t, index+1, failures)
+		assert.True(t, locked.IsZero())
+
+		_, delay, _, reserved = limiter.check(now, keys)
+		assert.Equal(t, duration, delay)
+		assert.True(t, reserved)
+	}
+
+	failures, locked = limiter.fail(now, keys)
+	assert.Equal(t, 4, failures)
+	assert.Equal(t, now.Add(time.Minute), locked)
+
+	// NOTE: other users connecting from the same address are locked out as well
+	_, _, locked, reserved = limiter.check(now, limiter.keys("other", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 4321}))
+	assert.Equal(t, now.Add(time.Minute), locked)
+	assert.False(t, reserved)
+
+	_, _, locked, reserved = limiter.check(now.Add(2*time.Minute), keys)
+	assert.True(t, locked.IsZero())
+	assert.True(t, reserved)
+
+	limiter.release(keys)
+	assert.Empty(t, limiter.attempts)
+
+	limiter.check(now, keys)
+	limiter.fail(now, keys)
+	limiter.check(now, keys)
+	limiter.reset(keys)
+	assert.Empty(t, limiter.attempts)
+}
+
+func TestAuthLimiterConcurrent(t *testing.T) {
+	t.Parallel()
+
+	limiter := &authLimiter{
+		config: AuthLimitConfig{
+			BaseDelay:       time.Second,
+			MaxDelay:        time.Minute,
+			MaxFailures:     4,
+			LockoutDuration: time.Minute,
+		},
+		attempts: make(map[string]*authAttempts),
+	}
+
+	now := time.Now()
+	keys := limiter.keys("admin", &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 1234})
+
+	type result struct {
+		delay    time.Duration
+		reserved bool
+	}
+
+	attempt := func(count int) []result {
+		var wg sync.WaitGroup
+		results := make(chan result, count)
+		for range count {
+			wg.Go(func() {
+				_, delay, _, reserved := limiter.check(now, keys)
+				results <- result{delay: delay, reserved: reserved}
+			})
+		}
+
+		wg.Wait()
+		close(results)
+
+		var collected []result
+		for result := range results {
+			collected = append(collected, result)
+		}
+
+		return collected
+	}
+
+	t.Run("without failures", func(t *testing.T) {
+		// NOTE: concurrent attempts without failures are neither delayed nor
+		// rejected, even when exceeding the maximum number of failures.
+		results := attempt(16)
+		for _, result := range results {
+			assert.True(t, result.reserved)
+			assert.Zero(t, result.delay)
+			limiter.reset(keys)
+		}
+
+		assert.Empty(t, limiter.attempts)
+	})
+
+	t.Run("with failures", func(t *testing.T) {
+		limiter.check(now, keys)
+		limiter.fail(now, keys)
+
+		// NOTE: concurrent attempts after a failure are limited to the
+		// remaining number of failures before a lockout.
+		var reserved int
+		for _, result := range attempt(16) {
+			if result.reserved {
+				assert.Equal(t, time.Second, result.delay)
+				reserved++
+			}
+		}
+
+		assert.Equal(t, 3, reserved)
+		for range reserved {
+			limiter.fail(now, keys)
+		}
+
+		_, _, locked, ok := limiter.check(now, keys)
+		assert.Equal(t, now.Add(time.Minute), locked)
+		assert.False(t, ok)
+	})
+}
+
+func TestLimitAuth(t *testing.T) {
+	t.Parallel()
+
+	validate := func(ctx context.Context, database, username, password string) (context.Context, bool, error) {
+		return ctx, password == "secret", nil
+	}
+
+	var mu sync.Mutex
+	var events []AuthEvent
+
+	config := AuthLimitConfig{
+		BaseDelay:       time.Millisecond,
+		MaxDelay:        10 * time.Millisecond,
+		MaxFailures:     2,
+		LockoutDuration: time.Minute,
+		OnEvent: func(ctx context.Context, event AuthEvent) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, event)
+		},
+	}
+
+	server, err := NewServer(nil, Logger(slogt.New(t)), SessionAuthStrategy(LimitAuth(config, ClearTextPassword(validate))))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connect := func(user, password string) error {
+		ctx := context.Background()
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s:%s@%s:%d?sslmode=disable", user, password, address.IP, address.Port))
+		if err != nil {
+			return err
+		}
+
+		return conn.Close(ctx)
+	}
+
+	require.NoError(t, connect("admin", "secret"))
+
+	err = connect("admin", "incorrect")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "28P01")
+
+	err = connect("admin", "incorrect")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "28P01")
+
+	err = connect("admin", "secret")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "too many failed authentication attempts")
+
+	mu.Lock()
+	defer mu.Unlock()
+
+	require.Len(t, events, 4)
+	assert.Equal(t, AuthSucceeded, events[0].Type)
+	assert.Equal(t, "admin", events[0].Username)
+	assert.NotNil(t, events[0].RemoteAddr)
+
+	assert.Equal(t, AuthFailed, events[1].Type)
+	assert.Equal(t, 1, events[1].Failures)
+	assert.Zero(t, events[1].Delay)
+	assert.Error(t, events[1].Err)
+
+	assert.Equal(t, AuthFailed, events[2].Type)
+	assert.Equal(t, 2, events[2].Failures)
+	assert.Equal(t, time.Millisecond, events[2].Delay)
+	assert.False(t, events[2].LockedUntil.IsZero())
+
+	assert.Equal(t, AuthLocked, events[3].Type)
+	assert.False(t, events[3].LockedUntil.IsZero())
+	assert.Equal(t, "locked", events[3].LogValue().Group()[0].Value.String())
+}
+
+func TestLimitAuthConcurrent(t *testing.T) {
+	t.Parallel()
+
+	validate := func(ctx context.Context, database, username, password string) (context.Context, bool, error) {
+		return ctx, password == "secret", nil
+	}
+
+	const connections = 16
+	events := make(chan AuthEvent, connections)
+	config := AuthLimitConfig{
+		BaseDelay:   time.Minute,
+		MaxDelay:    time.Minute,
+		MaxFailures: 2,
+		OnEvent: func(ctx context.Context, event AuthEvent) {
+			events <- event
+		},
+	}
+
+	server, err := NewServer(nil, Logger(slogt.New(t)), SessionAuthStrategy(LimitAuth(config, ClearTextPassword(validate))))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	// NOTE: connections opened concurrently by a pool are neither delayed nor
+	// rejected as long as no failed attempts have been made.
+	var wg sync.WaitGroup
+	for range connections {
+		wg.Go(func() {
+			ctx := context.Background()
+			conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://admin:secret@%s:%d?sslmode=disable", address.IP, address.Port))
+			if assert.NoError(t, err) {
+				assert.NoError(t, conn.Close(ctx))
+			}
+		})
+	}
+
+	wg.Wait()
+	close(events)
+
+	var count int
+	for event := range events {
+		assert.Equal(t, AuthSucceeded, event.Type)
+		assert.Zero(t, event.Delay)
+		count++
+	}
+
+	assert.Equal(t, connections, count)
+}
+
+func TestAuthenticationTimeout(t *testing.T) {
+	t.Parallel()
+
+	validate := func(ctx context.Context, database, username, password string) (context.Context, bool, error) {
+		return ctx, true, nil
+	}
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), AuthenticationTimeout(50*time.Millisecond), SessionAuthStrategy(ClearTextPassword(validate)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	t.Run("startup", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address.String())
+		require.NoError(t, err)
+		defer conn.Close() //nolint:errcheck
+
+		client := mock.NewClient(t, conn)
+		client.Error(t, "canceling authentication due to timeout")
+	})
+
+	t.Run("password", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address.String())
+		require.NoError(t, err)
+		defer conn.Close() //nolint:errcheck
+
+		client := mock.NewClient(t, conn)
+		client.Handshake(t)
+
+		typed, _, err := client.ReadTypedMsg()
+		require.NoError(t, err)
+		require.Equal(t, types.ServerAuth, typed)
+
+		client.Error(t, "canceling authentication due to timeout")
+	})
+
+	t.Run("authenticated", func(t *testing.T) {
+		ctx := context.Background()
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://admin:secret@%s:%d?sslmode=disable", address.IP, address.Port))
+		require.NoError(t, err)
+
+		time.Sleep(100 * time.Millisecond)
+		require.NoError(t, conn.Ping(ctx))
+		require.NoError(t, conn.Close(ctx))
+	})
+}
+
+func TestLimitAuthTimeout(t *testing.T) {
+	t.Parallel()
+
+	validate := func(ctx context.Context, database, username, password string) (context.Context, bool, error) {
+		return ctx, password == "secret", nil
+	}
+
+	events := make(chan AuthEvent, 2)
+	config := AuthLimitConfig{
+		BaseDelay: time.Minute,
+		MaxDelay:  time.Minute,
+		OnEvent: func(ctx context.Context, event AuthEvent) {
+			events <- event
+		},
+	}
+
+	server, err := NewServer(nil, Logger(slogt.New(t)), AuthenticationTimeout(100*time.Millisecond), SessionAuthStrategy(LimitAuth(config, ClearTextPassword(validate))))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connect := func(password string) error {
+		ctx := context.Background()
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://admin:%s@%s:%d?sslmode=disable", password, address.IP, address.Port))
+		if err != nil {
+			return err
+		}
+
+		return conn.Close(ctx)
+	}
+
+	err = connect("incorrect")
+	require.Error(t, err)
+	assert.Equal(t, AuthFailed, (<-events).Type)
+
+	// NOTE: the delay applied before the next attempt exceeds the
+	// authentication timeout and is aborted once the timeout expires.
+	start := time.Now()
+	err = connect("secret")
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), "canceling authentication due to timeout")
+	assert.Less(t, time.Since(start), 10*time.Second)
+
+	event := <-events
+	assert.Equal(t, AuthAborted, event.Type)
+	assert.Equal(t, time.Minute, event.Delay)
+}
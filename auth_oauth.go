@@ -0,0 +1,225 @@
+package wire
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+// oauthBearer is the SASL mechanism name of OAUTHBEARER as defined in RFC 7628.
+const oauthBearer = "OAUTHBEARER"
+
+// oauthKVSep separates the key/value pairs inside OAUTHBEARER messages.
+const oauthKVSep = '\x01'
+
+// oauthDiscoveryPath is the well-known path of the OpenID Connect discovery
+// document appended to the issuer.
+const oauthDiscoveryPath = "/.well-known/openid-configuration"
+
+// OAuthBearer announces to the client to authenticate using the OAUTHBEARER
+// SASL mechanism (RFC 7628) supported by PostgreSQL 18 clients. The bearer
+// token sent by the client is passed to the given validate function, the
+// returned context is used for the remainder of the connection allowing the
+// validated identity to be stored. Any error returned by the validate
+// function rejects the token. Clients which have not sent a token yet
+// receive the discovery document location of the given issuer together with
+// the required scope, allowing the client to obtain a token. If no valid token
+// has been provided, an error returned and the connection should be closed.
+func OAuthBearer(issuer, scope string, validate func(ctx context.Context, token string) (context.Context, error)) AuthStrategy {
+	return SASL(OAuthBearerMechanism(issuer, scope, validate))
+}
+
+// OAuthBearerMechanism constructs the OAUTHBEARER SASL mechanism which could
+// be combined with other mechanisms using the [SASL] strategy. See
+// [OAuthBearer] for more information about the given arguments.
+func OAuthBearerMechanism(issuer, scope string, validate func(ctx context.Context, token string) (context.Context, error)) SASLMechanism {
+	return &oauthMechanism{
+		issuer:   issuer,
+		scope:    scope,
+		validate: validate,
+	}
+}
+
+// oauthMechanism implements the OAUTHBEARER SASL mechanism.
+type oauthMechanism struct {
+	issuer   string
+	scope    string
+	validate func(ctx context.Context, token string) (context.Context, error)
+}
+
+func (mechanism *oauthMechanism) Name() string {
+	return oauthBearer
+}
+
+func (mechanism *oauthMechanism) Start(ctx context.Context) (SASLExchange, error) {
+	return &oauthExchange{
+		mechanism: mechanism,
+		username:  ClientParameters(ctx)[ParamUsername],
+	}, nil
+}
+
+// oauthDiscovery represents the error status returned to the client when no
+// valid token has been provided (RFC 7628, section 3.2.2).
+type oauthDiscovery struct {
+	Status        string `json:"status"`
+	Configuration string `json:"openid-configuration"`
+	Scope         string `json:"scope,omitempty"`
+}
+
+// oauthExchange holds the state of a single OAUTHBEARER authentication
+// exchange between the server and a client.
+type oauthExchange struct {
+	mechanism *oauthMechanism
+	username  string
+	failed    bool
+}
+
+// Next handles the client initial response. The error status is returned to
+// the client if no valid token has been provided. The client has to
+// acknowledge the error status before the exchange is failed.
+func (exchange *oauthExchange) Next(ctx context.Context, response []byte) (context.Context, []byte, bool, error) {
+	if exchange.failed {
+		if len(response) != 1 || response[0] != oauthKVSep {
+			return ctx, nil, false, newErrMalformedOAuth("client did not send a kvsep response")
+		}
+
+		err := fmt.Errorf("OAuth bearer authentication failed for user %q", exchange.username)
+		return ctx, nil, false, pgerror.WithSeverity(pgerror.WithCode(err, codes.InvalidAuthorizationSpecification), pgerror.LevelFatal)
+	}
+
+	token, err := parseOAuthInitialResponse(response)
+	if err != nil {
+		return ctx, nil, false, err
+	}
+
+	if token != "" {
+		validated, err := exchange.mechanism.validate(ctx, token)
+		if err == nil {
+			return validated, nil, true, nil
+		}
+	}
+
+	exchange.failed = true
+	challenge, err := json.Marshal(oauthDiscovery{
+		Status:        "invalid_token",
+		Configuration: exchange.mechanism.discoveryURI(),
+		Scope:         exchange.mechanism.scope,
+	})
+
+	return ctx, challenge, false, err
+}
+
+// discoveryURI returns the location of the OpenID Connect discovery document
+// of the configured issuer.
+func (mechanism *oauthMechanism) discoveryURI() string {
+	if strings.Contains(mechanism.issuer, "/.well-known/") {
+		return mechanism.issuer
+	}
+
+	return strings.TrimSuffix(mechanism.issuer, "/") + oauthDiscoveryPath
+}
+
+// parseOAuthInitialResponse parses the given OAUTHBEARER client initial
+// response and returns the included bearer token. An empty token is returned
+// when the client requests the discovery document.
+//
+// gs2-header kvsep *kvpair kvsep
+func parseOAuthInitialResponse(response []byte) (string, error) {
+	flag, rest, ok := strings.Cut(string(response), ",")
+	if !ok {
+		return "", newErrMalformedOAuth("missing GS2 header")
+	}
+
+	switch {
+	case flag == "n", flag == "y":
+	case strings.HasPrefix(flag, "p="):
+		return "", newErrMalformedOAuth("server does not support channel binding for OAuth")
+	default:
+		return "", newErrMalformedOAuth(fmt.Sprintf("unexpected channel-binding flag %q", flag))
+	}
+
+	authzid, rest, ok := strings.Cut(rest, ",")
+	if !ok {
+		return "", newErrMalformedOAuth("missing GS2 header")
+	}
+
+	if authzid != "" {
+		return "", newErrMalformedOAuth("client uses authorization identity, but it is not supported")
+	}
+
+	kvsep := string(oauthKVSep)
+	rest, ok = strings.CutPrefix(rest, kvsep)
+	if !ok {
+		return "", newErrMalformedOAuth("key-value separator expected after GS2 header")
+	}
+
+	rest, ok = strings.CutSuffix(rest, kvsep+kvsep)
+	if !ok {
+		return "", newErrMalformedOAuth("message did not contain a final terminator")
+	}
+
+	var auth *string
+	for _, pair := range strings.Split(rest, kvsep) {
+		key, value, ok := strings.Cut(pair, "=")
+		if !ok || key == "" {
+			return "", newErrMalformedOAuth("key-value pair is malformed")
+		}
+
+		if key != "auth" {
+			continue
+		}
+
+		if auth != nil {
+			return "", newErrMalformedOAuth("message contains multiple auth values")
+		}
+
+		auth = &value
+	}
+
+	if auth == nil {
+		return "", newErrMalformedOAuth("message does not contain an auth value")
+	}
+
+	// NOTE: an empty auth value indicates that the client requests the
+	// discovery document in order to obtain a token.
+	if *auth == "" {
+		return "", nil
+	}
+
+	scheme, token, ok := strings.Cut(*auth, " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", newErrMalformedOAuth("malformed Bearer token")
+	}
+
+	token = strings.TrimLeft(token, " ")
+	if token == "" || strings.ContainsFunc(token, func(r rune) bool { return !isOAuthTokenChar(r) }) {
+		return "", newErrMalformedOAuth("malformed Bearer token")
+	}
+
+	return token, nil
+}
+
+// isOAuthTokenChar checks whether the given rune is allowed inside a bearer
+// token (b64token, RFC 6750 section 2.1).
+func isOAuthTokenChar(r rune) bool {
+	switch {
+	case r >= 'a' && r <= 'z', r >= 'A' && r <= 'Z', r >= '0' && r <= '9':
+		return true
+	case strings.ContainsRune("-._~+/=", r):
+		return true
+	}
+
+	return false
+}
+
+// newErrMalformedOAuth is returned whenever the client sends a malformed
+// OAUTHBEARER message. The given detail describes what is wrong with the message.
+func newErrMalformedOAuth(detail string) error {
+	err := pgerror.WithDetail(errors.New("malformed OAUTHBEARER message"), detail)
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.ProtocolViolation), pgerror.LevelFatal)
+}
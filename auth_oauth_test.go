@@ -0,0 +1,90 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseOAuthInitialResponse(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		response string
+		token    string
+		err      bool
+	}{
+		"token":            {response: "n,,\x01auth=Bearer abc.DEF-123~+/=\x01\x01", token: "abc.DEF-123~+/="},
+		"case insensitive": {response: "y,,\x01host=localhost\x01auth=bearer token\x01\x01", token: "token"},
+		"discovery":        {response: "n,,\x01auth=\x01\x01", token: ""},
+		"channel binding":  {response: "p=tls-server-end-point,,\x01auth=Bearer token\x01\x01", err: true},
+		"authzid":          {response: "n,a=admin,\x01auth=Bearer token\x01\x01", err: true},
+		"missing auth":     {response: "n,,\x01host=localhost\x01\x01", err: true},
+		"missing final":    {response: "n,,\x01auth=Bearer token\x01", err: true},
+		"invalid scheme":   {response: "n,,\x01auth=Basic token\x01\x01", err: true},
+		"invalid token":    {response: "n,,\x01auth=Bearer to ken\x01\x01", err: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			token, err := parseOAuthInitialResponse([]byte(test.response))
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.token, token)
+		})
+	}
+}
+
+func TestOAuthBearer(t *testing.T) {
+	t.Parallel()
+
+	validate := func(ctx context.Context, token string) (context.Context, error) {
+		if token != "secret" {
+			return ctx, errors.New("invalid token")
+		}
+
+		return ctx, nil
+	}
+
+	server, err := NewServer(nil, Logger(slogt.New(t)), SessionAuthStrategy(OAuthBearer("https://issuer.example.com", "openid postgres", validate)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	t.Run("valid token", func(t *testing.T) {
+		client := saslClient(t, address)
+		require.Equal(t, []string{oauthBearer}, saslReadMechanisms(t, client))
+		saslWriteInitialResponse(t, client, oauthBearer, "n,,\x01auth=Bearer secret\x01\x01")
+
+		client.Authenticate(t)
+		client.ReadyForQuery(t, types.ServerIdle)
+	})
+
+	tests := map[string]string{
+		"discovery":     "n,,\x01auth=\x01\x01",
+		"invalid token": "n,,\x01auth=Bearer incorrect\x01\x01",
+	}
+
+	for name, response := range tests {
+		t.Run(name, func(t *testing.T) {
+			client := saslClient(t, address)
+			saslReadMechanisms(t, client)
+			saslWriteInitialResponse(t, client, oauthBearer, response)
+
+			require.Equal(t, int32(authSASLContinue), saslReadAuth(t, client))
+			assert.JSONEq(t, `{"status":"invalid_token","openid-configuration":"https://issuer.example.com/.well-known/openid-configuration","scope":"openid postgres"}`, string(client.Msg))
+
+			saslWriteResponse(t, client, "\x01")
+			client.Error(t, "^authentication failed$")
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/user"
+	"strconv"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+)
+
+// PeerCred represents the operating system credentials of the process
+// connected through a Unix domain socket.
+type PeerCred struct {
+	PID int32
+	UID uint32
+	GID uint32
+}
+
+// PeerMapper maps the operating system user name of the connected process to
+// the user provided inside the client parameters. The returned context is used
+// for the remainder of the connection. False is returned when the system user
+// is not allowed to connect as the given user.
+type PeerMapper func(ctx context.Context, systemUser string, user string) (context.Context, bool, error)
+
+// Peer authenticates clients connected through a Unix domain socket by
+// obtaining the operating system user of the connected process using
+// SO_PEERCRED, equivalent to the pg_hba.conf peer method. The given mapper is
+// used to validate whether the system user is allowed to connect as the user
+// provided inside the client parameters. The system user has to equal the
+// requested user if no mapper is given. Connections which are not made
+// through a Unix domain socket are rejected. If the system user is not
+// allowed to connect or any unexpected error occurs, an error returned and the
+// connection should be closed.
+func Peer(mapper PeerMapper) AuthStrategy {
+	if mapper == nil {
+		mapper = func(ctx context.Context, systemUser string, user string) (context.Context, bool, error) {
+			return ctx, systemUser == user, nil
+		}
+	}
+
+	return func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (_ context.Context, err error) {
+		username := ClientParameters(ctx)[ParamUsername]
+
+		cred := PeerCredentials(ctx)
+		if cred == nil {
+			authErr := errors.New("peer authentication is only supported on local sockets")
+			return ctx, writeAuthError(writer, pgerror.WithSeverity(pgerror.WithCode(authErr, codes.InvalidAuthorizationSpecification), pgerror.LevelFatal))
+		}
+
+		uid := strconv.FormatUint(uint64(cred.UID), 10)
+		system, err := user.LookupId(uid)
+		if err != nil {
+			authErr := fmt.Errorf("could not look up local user ID %s: %w", uid, err)
+			return ctx, writeAuthError(writer, pgerror.WithSeverity(pgerror.WithCode(authErr, codes.InvalidAuthorizationSpecification), pgerror.LevelFatal))
+		}
+
+		ctx, valid, err := mapper(ctx, system.Username, username)
+		if err != nil {
+			return ctx, err
+		}
+
+		if !valid {
+			authErr := fmt.Errorf("peer authentication failed for user %q", username)
+			return ctx, writeAuthError(writer, pgerror.WithSeverity(pgerror.WithCode(authErr, codes.InvalidAuthorizationSpecification), pgerror.LevelFatal))
+		}
+
+		return ctx, writeAuthType(writer, authOK)
+	}
+}
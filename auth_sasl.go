@@ -0,0 +1,244 @@
+package wire
+
+import (
+	"context"
+	"errors"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// SASLMechanism represents a SASL authentication mechanism which could be
+// announced to clients using the [SASL] authentication strategy. Each
+// mechanism is identified by its registered name (ex: SCRAM-SHA-256).
+type SASLMechanism interface {
+	// Name returns the registered name of the SASL mechanism.
+	Name() string
+	// Start is called once the client has selected the mechanism. A new
+	// exchange is constructed holding the state of a single authentication
+	// attempt.
+	Start(ctx context.Context) (SASLExchange, error)
+}
+
+// SASLExchange represents the state of a single SASL authentication exchange
+// between the server and a client.
+type SASLExchange interface {
+	// Next is called with every response sent by the client, starting with the
+	// initial response. The returned challenge is sent to the client. Done
+	// should be set once the client has been authenticated, any returned
+	// challenge is sent as additional data alongside the outcome. Returned
+	// errors close the connection, see [SASL] for the errors written to the
+	// client.
+	Next(ctx context.Context, response []byte) (_ context.Context, challenge []byte, done bool, err error)
+}
+
+// saslAvailability could be implemented by mechanisms which could only be
+// offered to some connections. Mechanisms which are not available are not
+// announced to the client.
+type saslAvailability interface {
+	available(ctx context.Context) bool
+}
+
+// SASL announces to the client to authenticate using one of the given SASL
+// mechanisms in order of preference. The message framing of the
+// SASLInitialResponse and SASLResponse messages is handled by the strategy,
+// the exchange itself is driven by the mechanism selected by the client. If
+// the provided credentials are invalid or any unexpected error occurs, an
+// error returned and the connection should be closed.
+//
+// NOTE: only protocol violations are written as-is to the client. Any other
+// error returned by a mechanism is reported to the client as a generic
+// authentication failure, preventing internal errors from being leaked to
+// unauthenticated clients. The underlying error is returned.
+func SASL(mechanisms ...SASLMechanism) AuthStrategy {
+	return func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (_ context.Context, err error) {
+		available := make([]SASLMechanism, 0, len(mechanisms))
+		names := make([]string, 0, len(mechanisms))
+		for _, mechanism := range mechanisms {
+			if availability, ok := mechanism.(saslAvailability); ok && !availability.available(ctx) {
+				continue
+			}
+
+			available = append(available, mechanism)
+			names = append(names, mechanism.Name())
+		}
+
+		if len(available) == 0 {
+			return ctx, writeAuthError(writer, newErrSASLProtocolViolation("no SASL authentication mechanisms available"))
+		}
+
+		ctx = setSASLMechanisms(ctx, names)
+		err = writeSASLMechanisms(writer, names...)
+		if err != nil {
+			return ctx, err
+		}
+
+		name, response, err := readSASLInitialResponse(reader)
+		if err != nil {
+			return ctx, writeSASLError(writer, err)
+		}
+
+		var selected SASLMechanism
+		for _, mechanism := range available {
+			if mechanism.Name() == name {
+				selected = mechanism
+				break
+			}
+		}
+
+		if selected == nil {
+			return ctx, writeAuthError(writer, newErrSASLProtocolViolation("client selected an invalid SASL authentication mechanism"))
+		}
+
+		exchange, err := selected.Start(ctx)
+		if err != nil {
+			return ctx, writeSASLError(writer, err)
+		}
+
+		// NOTE: clients are allowed to omit the initial response. An empty
+		// challenge is sent to the client to request the initial response in
+		// this case (RFC 4422, section 5).
+		if response == nil {
+			err = writeAuthData(writer, authSASLContinue, nil)
+			if err != nil {
+				return ctx, err
+			}
+
+			response, err = readSASLResponse(reader)
+			if err != nil {
+				return ctx, writeSASLError(writer, err)
+			}
+		}
+
+		for {
+			var challenge []byte
+			var done bool
+
+			ctx, challenge, done, err = exchange.Next(ctx, response)
+			if err != nil {
+				return ctx, writeSASLError(writer, err)
+			}
+
+			if done {
+				if challenge != nil {
+					err = writeAuthData(writer, authSASLFinal, challenge)
+					if err != nil {
+						return ctx, err
+					}
+				}
+
+				return ctx, writeAuthType(writer, authOK)
+			}
+
+			err = writeAuthData(writer, authSASLContinue, challenge)
+			if err != nil {
+				return ctx, err
+			}
+
+			response, err = readSASLResponse(reader)
+			if err != nil {
+				return ctx, writeSASLError(writer, err)
+			}
+		}
+	}
+}
+
+// newErrSASLProtocolViolation is returned whenever the client sends an
+// unexpected or malformed message during a SASL authentication exchange.
+func newErrSASLProtocolViolation(message string) error {
+	return pgerror.WithSeverity(pgerror.WithCode(errors.New(message), codes.ProtocolViolation), pgerror.LevelFatal)
+}
+
+// writeSASLError writes the given error returned during a SASL authentication
+// exchange to the client. Protocol violations are written as-is, any other
+// error is replaced by a generic authentication failure. The given error is
+// returned.
+func writeSASLError(writer *buffer.Writer, err error) error {
+	code := pgerror.GetCode(err)
+	if code == codes.ProtocolViolation {
+		return writeAuthError(writer, err)
+	}
+
+	if code != codes.InvalidPassword {
+		code = codes.InvalidAuthorizationSpecification
+	}
+
+	failure := pgerror.WithSeverity(pgerror.WithCode(errors.New("authentication failed"), code), pgerror.LevelFatal)
+	werr := WriteUnterminatedError(writer, failure)
+	if werr != nil {
+		return werr
+	}
+
+	return err
+}
+
+// writeSASLMechanisms writes a AuthenticationSASL message to the client
+// announcing the given SASL mechanisms in order of preference.
+func writeSASLMechanisms(writer *buffer.Writer, mechanisms ...string) error {
+	writer.Start(types.ServerAuth)
+	writer.AddInt32(int32(authSASL))
+	for _, mechanism := range mechanisms {
+		writer.AddString(mechanism)
+		writer.AddNullTerminate()
+	}
+	writer.AddNullTerminate()
+	return writer.End()
+}
+
+// readSASLInitialResponse reads a SASLInitialResponse message sent by the
+// client. The selected mechanism name is returned together with the initial
+// client response. A nil response is returned when the client has not
+// included an initial response.
+func readSASLInitialResponse(reader *buffer.Reader) (mechanism string, response []byte, err error) {
+	t, _, err := reader.ReadTypedMsg()
+	if err != nil {
+		return "", nil, err
+	}
+
+	if t != types.ClientPassword {
+		return "", nil, newErrSASLProtocolViolation("expected SASL response")
+	}
+
+	mechanism, err = reader.GetString()
+	if err != nil {
+		return "", nil, err
+	}
+
+	length, err := reader.GetInt32()
+	if err != nil {
+		return "", nil, err
+	}
+
+	// NOTE: a length of -1 indicates that no initial response is present
+	if length == -1 {
+		return mechanism, nil, nil
+	}
+
+	if length < 0 {
+		return "", nil, newErrSASLProtocolViolation("invalid SASL initial response length")
+	}
+
+	response, err = reader.GetBytes(int(length))
+	if err != nil {
+		return "", nil, err
+	}
+
+	return mechanism, response, nil
+}
+
+// readSASLResponse reads a SASLResponse message sent by the client and returns
+// the mechanism specific message data.
+func readSASLResponse(reader *buffer.Reader) ([]byte, error) {
+	t, _, err := reader.ReadTypedMsg()
+	if err != nil {
+		return nil, err
+	}
+
+	if t != types.ClientPassword {
+		return nil, newErrSASLProtocolViolation("expected SASL response")
+	}
+
+	return reader.GetBytes(len(reader.Msg))
+}
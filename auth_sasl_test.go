@@ -0,0 +1,209 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+
+	"github.com/jeroenrinzema/psql-wire/pkg/mock"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/require"
+)
+
+// tokenMechanism is a SASL mechanism used for testing purposes which expects
+// the client to send the configured token.
+type tokenMechanism struct {
+	token string
+}
+
+func (mechanism *tokenMechanism) Name() string { return "X-TOKEN" }
+
+func (mechanism *tokenMechanism) Start(ctx context.Context) (SASLExchange, error) {
+	return &tokenExchange{token: mechanism.token}, nil
+}
+
+type tokenExchange struct {
+	token     string
+	challenge bool
+}
+
+func (exchange *tokenExchange) Next(ctx context.Context, response []byte) (context.Context, []byte, bool, error) {
+	if !exchange.challenge {
+		exchange.challenge = true
+		return ctx, []byte("token?"), false, nil
+	}
+
+	if string(response) != exchange.token {
+		return ctx, nil, false, newErrSASLProtocolViolation("invalid token")
+	}
+
+	return ctx, nil, true, nil
+}
+
+func TestSASL(t *testing.T) {
+	t.Parallel()
+
+	verifier, err := NewScramVerifier("secret")
+	require.NoError(t, err)
+
+	lookup := func(ctx context.Context, username string) (ScramVerifier, error) {
+		return verifier, nil
+	}
+
+	strategy := SASL(ScramSHA256PlusMechanism(lookup), &tokenMechanism{token: "secret"}, ScramSHA256Mechanism(lookup))
+	server, err := NewServer(nil, Logger(slogt.New(t)), SessionAuthStrategy(strategy))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	t.Run("mechanisms", func(t *testing.T) {
+		client := saslClient(t, address)
+		mechanisms := saslReadMechanisms(t, client)
+		require.Equal(t, []string{"X-TOKEN", scramSHA256}, mechanisms)
+	})
+
+	t.Run("without initial response", func(t *testing.T) {
+		client := saslClient(t, address)
+		saslReadMechanisms(t, client)
+
+		client.Start(types.ClientPassword)
+		client.AddString("X-TOKEN")
+		client.AddNullTerminate()
+		client.AddInt32(-1)
+		require.NoError(t, client.End())
+
+		require.Equal(t, int32(authSASLContinue), saslReadAuth(t, client))
+		require.Empty(t, client.Msg)
+		saslWriteResponse(t, client, "initial")
+
+		require.Equal(t, int32(authSASLContinue), saslReadAuth(t, client))
+		require.Equal(t, "token?", string(client.Msg))
+		saslWriteResponse(t, client, "secret")
+
+		client.Authenticate(t)
+		client.ReadyForQuery(t, types.ServerIdle)
+	})
+
+	t.Run("invalid response", func(t *testing.T) {
+		client := saslClient(t, address)
+		saslReadMechanisms(t, client)
+		saslWriteInitialResponse(t, client, "X-TOKEN", "initial")
+
+		require.Equal(t, int32(authSASLContinue), saslReadAuth(t, client))
+		saslWriteResponse(t, client, "incorrect")
+		client.Error(t, "invalid token")
+	})
+
+	t.Run("unknown mechanism", func(t *testing.T) {
+		client := saslClient(t, address)
+		saslReadMechanisms(t, client)
+		saslWriteInitialResponse(t, client, scramSHA256Plus, "p=tls-server-end-point,,n=,r=nonce")
+		client.Error(t, "invalid SASL authentication mechanism")
+	})
+}
+
+func TestSASLNoMechanismsAvailable(t *testing.T) {
+	t.Parallel()
+
+	lookup := func(ctx context.Context, username string) (ScramVerifier, error) {
+		return ScramVerifier{}, errors.New("unexpected lookup")
+	}
+
+	server, err := NewServer(nil, Logger(slogt.New(t)), SessionAuthStrategy(SASL(ScramSHA256PlusMechanism(lookup))))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	client := saslClient(t, address)
+	client.Error(t, "no SASL authentication mechanisms available")
+}
+
+func TestSASLInternalError(t *testing.T) {
+	t.Parallel()
+
+	lookup := func(ctx context.Context, username string) (ScramVerifier, error) {
+		return ScramVerifier{}, errors.New("connection refused by verifier store")
+	}
+
+	server, err := NewServer(nil, Logger(slogt.New(t)), SessionAuthStrategy(SASL(ScramSHA256Mechanism(lookup))))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	// NOTE: internal errors are not leaked to unauthenticated clients
+	client := saslClient(t, address)
+	saslReadMechanisms(t, client)
+	saslWriteInitialResponse(t, client, scramSHA256, "n,,n=,r=nonce")
+	client.Error(t, "^authentication failed$")
+}
+
+// saslClient opens a new connection to the given address and performs a
+// handshake for the admin user.
+func saslClient(t *testing.T, address *net.TCPAddr) *mock.Client {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+
+	client := mock.NewClient(t, conn)
+	client.HandshakeProtocol(t, types.Version30, "user", "admin")
+	return client
+}
+
+// saslReadMechanisms reads the AuthenticationSASL message and returns the
+// announced mechanisms.
+func saslReadMechanisms(t *testing.T, client *mock.Client) []string {
+	t.Helper()
+
+	status := saslReadAuth(t, client)
+	require.Equal(t, int32(authSASL), status)
+
+	var mechanisms []string
+	for {
+		mechanism, err := client.GetString()
+		require.NoError(t, err)
+		if mechanism == "" {
+			return mechanisms
+		}
+
+		mechanisms = append(mechanisms, mechanism)
+	}
+}
+
+// saslWriteInitialResponse writes a SASLInitialResponse message selecting the
+// given mechanism.
+func saslWriteInitialResponse(t *testing.T, client *mock.Client, mechanism, response string) {
+	t.Helper()
+
+	client.Start(types.ClientPassword)
+	client.AddString(mechanism)
+	client.AddNullTerminate()
+	client.AddInt32(int32(len(response)))
+	client.AddBytes([]byte(response))
+	require.NoError(t, client.End())
+}
+
+// saslReadAuth reads an authentication message and returns its status.
+func saslReadAuth(t *testing.T, client *mock.Client) int32 {
+	t.Helper()
+
+	typed, _, err := client.ReadTypedMsg()
+	require.NoError(t, err)
+	require.Equal(t, types.ServerAuth, typed)
+
+	status, err := client.GetInt32()
+	require.NoError(t, err)
+	return status
+}
+
+// saslWriteResponse writes a SASLResponse message containing the given data.
+func saslWriteResponse(t *testing.T, client *mock.Client, response string) {
+	t.Helper()
+
+	client.Start(types.ClientPassword)
+	client.AddBytes([]byte(response))
+	require.NoError(t, client.End())
+}
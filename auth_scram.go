@@ -0,0 +1,462 @@
+package wire
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/pbkdf2"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+const (
+	// scramSHA256 is the SASL mechanism name of SCRAM-SHA-256 as defined in RFC 7677.
+	scramSHA256 = "SCRAM-SHA-256"
+	// scramSHA256Plus is the SASL mechanism name of SCRAM-SHA-256 including
+	// channel binding as defined in RFC 7677.
+	scramSHA256Plus = "SCRAM-SHA-256-PLUS"
+	// scramChannelBinding is the only supported channel binding type.
+	scramChannelBinding = "tls-server-end-point"
+)
+
+// DefaultScramIterations represents the default PBKDF2 iteration count used
+// when deriving new SCRAM verifiers. The value matches the PostgreSQL default
+// of the scram_iterations setting.
+const DefaultScramIterations = 4096
+
+const (
+	// scramSaltLength represents the length of randomly generated salts.
+	scramSaltLength = 16
+	// scramNonceLength represents the amount of random bytes included inside
+	// the server nonce.
+	scramNonceLength = 18
+)
+
+// ScramVerifier represents the server-side stored credentials of a single
+// user used during a SCRAM-SHA-256 authentication exchange. A verifier does
+// not contain the password of the user and could be stored safely. Verifiers
+// could be constructed from plaintext passwords using [NewScramVerifier] or
+// parsed from the PostgreSQL pg_authid format using [ParseScramVerifier].
+type ScramVerifier struct {
+	Salt       []byte
+	Iterations int
+	StoredKey  []byte
+	ServerKey  []byte
+}
+
+// IsZero returns whether the given verifier is empty. An empty verifier
+// indicates that no credentials are known for the given user.
+func (verifier ScramVerifier) IsZero() bool {
+	return len(verifier.StoredKey) == 0 && len(verifier.ServerKey) == 0
+}
+
+// String encodes the verifier using the PostgreSQL pg_authid format:
+// SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+func (verifier ScramVerifier) String() string {
+	return fmt.Sprintf("%s$%d:%s$%s:%s", scramSHA256, verifier.Iterations,
+		base64.StdEncoding.EncodeToString(verifier.Salt),
+		base64.StdEncoding.EncodeToString(verifier.StoredKey),
+		base64.StdEncoding.EncodeToString(verifier.ServerKey))
+}
+
+// NewScramVerifier derives a new SCRAM-SHA-256 verifier for the given
+// plaintext password using a random salt and the default iteration count.
+func NewScramVerifier(password string) (ScramVerifier, error) {
+	salt := make([]byte, scramSaltLength)
+	rand.Read(salt) //nolint:errcheck
+
+	return DeriveScramVerifier(password, salt, DefaultScramIterations)
+}
+
+// DeriveScramVerifier derives a SCRAM-SHA-256 verifier for the given plaintext
+// password using the given salt and iteration count as defined in RFC 5802.
+//
+// NOTE: PostgreSQL normalizes passwords using SASLprep before deriving the
+// verifier. Passwords are used as-is, which is identical for passwords
+// consisting only out of ASCII characters.
+func DeriveScramVerifier(password string, salt []byte, iterations int) (ScramVerifier, error) {
+	if iterations <= 0 {
+		return ScramVerifier{}, fmt.Errorf("invalid SCRAM iteration count: %d", iterations)
+	}
+
+	salted, err := pbkdf2.Key(sha256.New, password, salt, iterations, sha256.Size)
+	if err != nil {
+		return ScramVerifier{}, err
+	}
+
+	storedKey := sha256.Sum256(scramHMAC(salted, "Client Key"))
+
+	return ScramVerifier{
+		Salt:       salt,
+		Iterations: iterations,
+		StoredKey:  storedKey[:],
+		ServerKey:  scramHMAC(salted, "Server Key"),
+	}, nil
+}
+
+// ParseScramVerifier parses the given SCRAM-SHA-256 secret stored using the
+// PostgreSQL pg_authid format: SCRAM-SHA-256$<iterations>:<salt>$<StoredKey>:<ServerKey>
+func ParseScramVerifier(secret string) (ScramVerifier, error) {
+	mechanism, rest, _ := strings.Cut(secret, "$")
+	if mechanism != scramSHA256 {
+		return ScramVerifier{}, errors.New("unsupported SCRAM secret mechanism")
+	}
+
+	params, keys, ok := strings.Cut(rest, "$")
+	if !ok {
+		return ScramVerifier{}, errors.New("malformed SCRAM secret")
+	}
+
+	iterations, salt, ok := strings.Cut(params, ":")
+	if !ok {
+		return ScramVerifier{}, errors.New("malformed SCRAM secret parameters")
+	}
+
+	storedKey, serverKey, ok := strings.Cut(keys, ":")
+	if !ok {
+		return ScramVerifier{}, errors.New("malformed SCRAM secret keys")
+	}
+
+	var err error
+	verifier := ScramVerifier{}
+
+	verifier.Iterations, err = strconv.Atoi(iterations)
+	if err != nil || verifier.Iterations <= 0 {
+		return ScramVerifier{}, fmt.Errorf("invalid SCRAM iteration count: %s", iterations)
+	}
+
+	verifier.Salt, err = base64.StdEncoding.DecodeString(salt)
+	if err != nil {
+		return ScramVerifier{}, fmt.Errorf("invalid SCRAM salt: %w", err)
+	}
+
+	verifier.StoredKey, err = base64.StdEncoding.DecodeString(storedKey)
+	if err != nil || len(verifier.StoredKey) != sha256.Size {
+		return ScramVerifier{}, errors.New("invalid SCRAM stored key")
+	}
+
+	verifier.ServerKey, err = base64.StdEncoding.DecodeString(serverKey)
+	if err != nil || len(verifier.ServerKey) != sha256.Size {
+		return ScramVerifier{}, errors.New("invalid SCRAM server key")
+	}
+
+	return verifier, nil
+}
+
+// scramMockNonce is used to derive stable salts for unknown users, mimicking
+// the PostgreSQL behaviour of not exposing user existence.
+var scramMockNonce = func() []byte {
+	nonce := make([]byte, sha256.Size)
+	rand.Read(nonce) //nolint:errcheck
+	return nonce
+}()
+
+// ScramSHA256 announces to the client to authenticate using the SCRAM-SHA-256
+// SASL mechanism (RFC 7677). The given lookup function is called to retrieve
+// the stored verifier of the user provided inside the client parameters. A
+// zero verifier indicates that the user is unknown. The exchange is completed
+// using a mock verifier in this case, preventing clients from learning which
+// users exist. If the provided credentials are invalid or any unexpected error
+// occurs, an error returned and the connection should be closed.
+//
+// The SCRAM-SHA-256-PLUS mechanism is additionally announced once the
+// connection has been upgraded to TLS. Clients selecting this mechanism bind
+// the exchange to the server certificate using the tls-server-end-point
+// channel binding type, allowing clients to detect man-in-the-middle TLS
+// termination.
+func ScramSHA256(lookup func(ctx context.Context, username string) (ScramVerifier, error)) AuthStrategy {
+	return SASL(ScramSHA256PlusMechanism(lookup), ScramSHA256Mechanism(lookup))
+}
+
+// ScramSHA256Mechanism constructs the SCRAM-SHA-256 SASL mechanism which could
+// be combined with other mechanisms using the [SASL] strategy. See
+// [ScramSHA256] for more information about the given lookup function.
+func ScramSHA256Mechanism(lookup func(ctx context.Context, username string) (ScramVerifier, error)) SASLMechanism {
+	return &scramMechanism{lookup: lookup}
+}
+
+// ScramSHA256PlusMechanism constructs the SCRAM-SHA-256-PLUS SASL mechanism
+// using the tls-server-end-point channel binding type. The mechanism is only
+// announced to clients connected over TLS. See [ScramSHA256] for more
+// information about the given lookup function.
+func ScramSHA256PlusMechanism(lookup func(ctx context.Context, username string) (ScramVerifier, error)) SASLMechanism {
+	return &scramMechanism{lookup: lookup, plus: true}
+}
+
+// scramMechanism implements the SCRAM-SHA-256(-PLUS) SASL mechanisms.
+type scramMechanism struct {
+	lookup func(ctx context.Context, username string) (ScramVerifier, error)
+	plus   bool
+}
+
+func (mechanism *scramMechanism) Name() string {
+	if mechanism.plus {
+		return scramSHA256Plus
+	}
+
+	return scramSHA256
+}
+
+// available checks whether channel binding data could be computed for the
+// given connection when channel binding is required.
+func (mechanism *scramMechanism) available(ctx context.Context) bool {
+	if !mechanism.plus {
+		return true
+	}
+
+	_, err := scramChannelBindingData(ctx)
+	return err == nil
+}
+
+func (mechanism *scramMechanism) Start(ctx context.Context) (SASLExchange, error) {
+	username := ClientParameters(ctx)[ParamUsername]
+	verifier, err := mechanism.lookup(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+
+	exchange := &scramExchange{
+		username: username,
+		verifier: verifier,
+		plus:     mechanism.plus,
+	}
+
+	// NOTE: channel binding data is only set when the server announced
+	// channel binding support to the client.
+	if slices.Contains(SASLMechanisms(ctx), scramSHA256Plus) {
+		exchange.channelBinding, err = scramChannelBindingData(ctx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if verifier.IsZero() {
+		exchange.verifier = mockScramVerifier(scramMockNonce, username)
+		exchange.mock = true
+	}
+
+	return exchange, nil
+}
+
+// scramChannelBindingData returns the tls-server-end-point channel binding
+// data of the given connection. An error is returned when the connection is
+// not secured or when the binding data could not be computed.
+func scramChannelBindingData(ctx context.Context) ([]byte, error) {
+	if TLSConnectionState(ctx) == nil {
+		return nil, errors.New("channel binding requires a TLS connection")
+	}
+
+	return tlsServerEndPoint(serverCertificate(ctx))
+}
+
+// scramExchange holds the state of a single SCRAM-SHA-256 authentication
+// exchange between the server and a client.
+type scramExchange struct {
+	username string
+	verifier ScramVerifier
+	mock     bool
+	// plus indicates whether the client selected the SCRAM-SHA-256-PLUS
+	// mechanism. The channel binding data is set whenever the server is able
+	// to offer channel binding to the client, cbind is set once the client
+	// has selected to use channel binding.
+	plus            bool
+	channelBinding  []byte
+	cbind           []byte
+	gs2Header       string
+	clientFirstBare string
+	serverFirst     string
+	nonce           string
+}
+
+// Next handles the client-first-message followed by the client-final-message.
+func (exchange *scramExchange) Next(ctx context.Context, response []byte) (context.Context, []byte, bool, error) {
+	if exchange.serverFirst == "" {
+		challenge, err := exchange.first(response)
+		return ctx, challenge, false, err
+	}
+
+	outcome, err := exchange.final(response)
+	return ctx, outcome, true, err
+}
+
+// first handles the client-first-message and returns the server-first-message.
+func (exchange *scramExchange) first(message []byte) ([]byte, error) {
+	if len(message) == 0 {
+		return nil, newErrMalformedScram("client-first-message is empty")
+	}
+
+	// NOTE: the client-first-message starts with the GS2 header containing
+	// the channel binding flag and an optional authorization identity:
+	// gs2-cbind-flag "," [ authzid ] "," client-first-message-bare
+	flag, rest, ok := strings.Cut(string(message), ",")
+	if !ok {
+		return nil, newErrMalformedScram("missing GS2 header")
+	}
+
+	switch {
+	case flag == "n":
+		if exchange.plus {
+			return nil, newErrSASLProtocolViolation("the client selected SCRAM-SHA-256-PLUS, but the SCRAM message does not include channel binding data")
+		}
+	case flag == "y":
+		// NOTE: the client supports channel binding but thinks the server
+		// does not. This could indicate a downgrade attack when channel
+		// binding has been offered by the server.
+		if exchange.plus {
+			return nil, newErrSASLProtocolViolation("the client selected SCRAM-SHA-256-PLUS, but the SCRAM message does not include channel binding data")
+		}
+
+		if exchange.channelBinding != nil {
+			err := pgerror.WithDetail(errors.New("SCRAM channel binding negotiation error"), "The client supports SCRAM channel binding but thinks the server does not. However, this server does support channel binding.")
+			return nil, pgerror.WithSeverity(pgerror.WithCode(err, codes.ProtocolViolation), pgerror.LevelFatal)
+		}
+	case strings.HasPrefix(flag, "p="):
+		if !exchange.plus {
+			return nil, newErrSASLProtocolViolation("the client selected SCRAM-SHA-256 without channel binding, but the SCRAM message includes channel binding data")
+		}
+
+		if flag != "p="+scramChannelBinding {
+			return nil, newErrMalformedScram(fmt.Sprintf("unsupported SCRAM channel-binding type %q", strings.TrimPrefix(flag, "p=")))
+		}
+
+		exchange.cbind = exchange.channelBinding
+	default:
+		return nil, newErrMalformedScram(fmt.Sprintf("unexpected channel-binding flag %q", flag))
+	}
+
+	authzid, bare, ok := strings.Cut(rest, ",")
+	if !ok {
+		return nil, newErrMalformedScram("missing GS2 header")
+	}
+
+	if authzid != "" {
+		return nil, newErrMalformedScram("client uses authorization identity, but it is not supported")
+	}
+
+	attrs := strings.Split(bare, ",")
+	if len(attrs) < 2 {
+		return nil, newErrMalformedScram("client-first-message-bare is incomplete")
+	}
+
+	if strings.HasPrefix(attrs[0], "m=") {
+		return nil, newErrMalformedScram("client requires an unsupported SCRAM extension")
+	}
+
+	// NOTE: the username inside the SCRAM exchange is ignored. The username
+	// send inside the startup message is used instead, matching PostgreSQL.
+	if !strings.HasPrefix(attrs[0], "n=") {
+		return nil, newErrMalformedScram("expected attribute \"n\"")
+	}
+
+	clientNonce, ok := strings.CutPrefix(attrs[1], "r=")
+	if !ok || clientNonce == "" {
+		return nil, newErrMalformedScram("expected attribute \"r\"")
+	}
+
+	serverNonce := make([]byte, scramNonceLength)
+	rand.Read(serverNonce) //nolint:errcheck
+
+	exchange.gs2Header = flag + "," + authzid + ","
+	exchange.clientFirstBare = bare
+	exchange.nonce = clientNonce + base64.StdEncoding.EncodeToString(serverNonce)
+	exchange.serverFirst = fmt.Sprintf("r=%s,s=%s,i=%d", exchange.nonce,
+		base64.StdEncoding.EncodeToString(exchange.verifier.Salt), exchange.verifier.Iterations)
+
+	return []byte(exchange.serverFirst), nil
+}
+
+// final handles the client-final-message and returns the server-final-message
+// once the client proof has been verified.
+func (exchange *scramExchange) final(message []byte) ([]byte, error) {
+	// NOTE: the client proof is always the last attribute inside the
+	// client-final-message. Everything preceding it is part of the signed
+	// authentication message.
+	index := strings.LastIndex(string(message), ",p=")
+	if index == -1 {
+		return nil, newErrMalformedScram("could not find proof in client-final-message")
+	}
+
+	withoutProof := string(message[:index])
+	proof, err := base64.StdEncoding.DecodeString(string(message[index+3:]))
+	if err != nil || len(proof) != sha256.Size {
+		return nil, newErrMalformedScram("malformed proof in client-final-message")
+	}
+
+	attrs := strings.Split(withoutProof, ",")
+	if len(attrs) < 2 {
+		return nil, newErrMalformedScram("client-final-message is incomplete")
+	}
+
+	binding, ok := strings.CutPrefix(attrs[0], "c=")
+	if !ok {
+		return nil, newErrMalformedScram("expected attribute \"c\"")
+	}
+
+	// NOTE: the channel binding attribute contains the GS2 header followed by
+	// the channel binding data when channel binding is used.
+	expected := append([]byte(exchange.gs2Header), exchange.cbind...)
+	cbind, err := base64.StdEncoding.DecodeString(binding)
+	if err != nil || subtle.ConstantTimeCompare(cbind, expected) != 1 {
+		if exchange.cbind != nil {
+			return nil, newErrSASLProtocolViolation("SCRAM channel binding check failed")
+		}
+
+		return nil, newErrSASLProtocolViolation("unexpected SCRAM channel-binding attribute in client-final-message")
+	}
+
+	nonce, ok := strings.CutPrefix(attrs[1], "r=")
+	if !ok || nonce != exchange.nonce {
+		return nil, newErrMalformedScram("nonce does not match")
+	}
+
+	auth := exchange.clientFirstBare + "," + exchange.serverFirst + "," + withoutProof
+
+	signature := scramHMAC(exchange.verifier.StoredKey, auth)
+	clientKey := make([]byte, len(proof))
+	subtle.XORBytes(clientKey, proof, signature)
+	storedKey := sha256.Sum256(clientKey)
+
+	if subtle.ConstantTimeCompare(storedKey[:], exchange.verifier.StoredKey) != 1 || exchange.mock {
+		err := fmt.Errorf("password authentication failed for user %q", exchange.username)
+		return nil, pgerror.WithSeverity(pgerror.WithCode(err, codes.InvalidPassword), pgerror.LevelFatal)
+	}
+
+	verification := scramHMAC(exchange.verifier.ServerKey, auth)
+	return []byte("v=" + base64.StdEncoding.EncodeToString(verification)), nil
+}
+
+// mockScramVerifier constructs a verifier for a non-existing user. The salt is
+// derived from the given nonce and username so that repeated attempts for the
+// same user result in the same salt.
+func mockScramVerifier(nonce []byte, username string) ScramVerifier {
+	salt := scramHMAC(nonce, username)[:scramSaltLength]
+	return ScramVerifier{
+		Salt:       salt,
+		Iterations: DefaultScramIterations,
+		StoredKey:  make([]byte, sha256.Size),
+		ServerKey:  make([]byte, sha256.Size),
+	}
+}
+
+// newErrMalformedScram is returned whenever the client sends a malformed SCRAM
+// message. The given detail describes what is wrong with the message.
+func newErrMalformedScram(detail string) error {
+	err := pgerror.WithDetail(errors.New("malformed SCRAM message"), detail)
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.ProtocolViolation), pgerror.LevelFatal)
+}
+
+// scramHMAC computes the HMAC-SHA-256 of the given message using the given key.
+func scramHMAC(key []byte, message string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(message))
+	return mac.Sum(nil)
+}
@@ -0,0 +1,269 @@
+package wire
+
+import (
+	"context"
+	"crypto/pbkdf2"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/tls"
+	"database/sql"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/mock"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestScramVerifierEncoding(t *testing.T) {
+	t.Parallel()
+
+	verifier, err := NewScramVerifier("password")
+	require.NoError(t, err)
+	assert.Len(t, verifier.Salt, scramSaltLength)
+	assert.Equal(t, DefaultScramIterations, verifier.Iterations)
+
+	parsed, err := ParseScramVerifier(verifier.String())
+	require.NoError(t, err)
+	assert.Equal(t, verifier, parsed)
+
+	derived, err := DeriveScramVerifier("password", verifier.Salt, verifier.Iterations)
+	require.NoError(t, err)
+	assert.Equal(t, verifier, derived)
+}
+
+func TestParseScramVerifierInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"empty":              "",
+		"md5":                "md5a3556571e93b0d20722ba62be61e8c2d",
+		"missing keys":       "SCRAM-SHA-256$4096:c2FsdA==",
+		"invalid iterations": "SCRAM-SHA-256$zero:c2FsdA==$AAAA:AAAA",
+		"invalid stored key": "SCRAM-SHA-256$4096:c2FsdA==$AAAA:AAAA",
+	}
+
+	for name, secret := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseScramVerifier(secret)
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestScramSHA256(t *testing.T) {
+	t.Parallel()
+
+	verifier, err := NewScramVerifier("secret")
+	require.NoError(t, err)
+
+	lookup := func(ctx context.Context, username string) (ScramVerifier, error) {
+		if username != "admin" {
+			return ScramVerifier{}, nil
+		}
+
+		return verifier, nil
+	}
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), SessionAuthStrategy(ScramSHA256(lookup)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	t.Run("jackc/pgx", func(t *testing.T) {
+		ctx := context.Background()
+		connstr := fmt.Sprintf("postgres://admin:secret@%s:%d", address.IP, address.Port)
+		conn, err := pgx.Connect(ctx, connstr)
+		require.NoError(t, err)
+		require.NoError(t, conn.Close(ctx))
+	})
+
+	t.Run("lib/pq", func(t *testing.T) {
+		connstr := fmt.Sprintf("host=%s port=%d user=admin password=secret sslmode=disable", address.IP, address.Port)
+		conn, err := sql.Open("postgres", connstr)
+		require.NoError(t, err)
+		require.NoError(t, conn.Ping())
+		require.NoError(t, conn.Close())
+	})
+
+	tests := map[string]string{
+		"invalid password": "postgres://admin:incorrect@%s:%d",
+		"unknown user":     "postgres://unknown:secret@%s:%d",
+	}
+
+	for name, connstr := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			_, err := pgx.Connect(ctx, fmt.Sprintf(connstr, address.IP, address.Port))
+			require.Error(t, err)
+			assert.Contains(t, err.Error(), "28P01")
+		})
+	}
+}
+
+func TestScramSHA256ChannelBinding(t *testing.T) {
+	t.Parallel()
+
+	cert, err := generateTestCert()
+	require.NoError(t, err)
+
+	verifier, err := NewScramVerifier("secret")
+	require.NoError(t, err)
+
+	lookup := func(ctx context.Context, username string) (ScramVerifier, error) {
+		return verifier, nil
+	}
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	auth := func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (context.Context, error) {
+		if TLSConnectionState(ctx) == nil {
+			return ctx, errors.New("expected a TLS connection state")
+		}
+
+		return ScramSHA256(lookup)(ctx, writer, reader)
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), SessionAuthStrategy(auth), TLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	t.Run("plus", func(t *testing.T) {
+		client, state := scramTLSClient(t, address)
+		mechanisms := saslReadMechanisms(t, client)
+		require.Equal(t, []string{scramSHA256Plus, scramSHA256}, mechanisms)
+
+		binding := sha256.Sum256(state.PeerCertificates[0].Raw)
+		scramAuthenticate(t, client, scramSHA256Plus, "p=tls-server-end-point,,", binding[:])
+		require.Equal(t, int32(authSASLFinal), saslReadAuth(t, client))
+		client.Authenticate(t)
+		client.ReadyForQuery(t, types.ServerIdle)
+	})
+
+	t.Run("invalid binding data", func(t *testing.T) {
+		client, _ := scramTLSClient(t, address)
+		saslReadMechanisms(t, client)
+
+		binding := sha256.Sum256([]byte("man-in-the-middle"))
+		scramAuthenticate(t, client, scramSHA256Plus, "p=tls-server-end-point,,", binding[:])
+		client.Error(t, "SCRAM channel binding check failed")
+	})
+
+	t.Run("downgrade", func(t *testing.T) {
+		client, _ := scramTLSClient(t, address)
+		saslReadMechanisms(t, client)
+
+		saslWriteInitialResponse(t, client, scramSHA256, "y,,n=,r=nonce")
+		client.Error(t, "SCRAM channel binding negotiation error")
+	})
+
+	t.Run("unsupported binding type", func(t *testing.T) {
+		client, _ := scramTLSClient(t, address)
+		saslReadMechanisms(t, client)
+
+		saslWriteInitialResponse(t, client, scramSHA256Plus, "p=tls-unique,,n=,r=nonce")
+		client.Error(t, "malformed SCRAM message")
+	})
+
+	t.Run("jackc/pgx", func(t *testing.T) {
+		ctx := context.Background()
+		connstr := fmt.Sprintf("postgres://admin:secret@%s:%d?sslmode=require", address.IP, address.Port)
+		conn, err := pgx.Connect(ctx, connstr)
+		require.NoError(t, err)
+		require.NoError(t, conn.Close(ctx))
+	})
+}
+
+// scramTLSClient opens a new connection to the given address, upgrades it to
+// TLS and performs a handshake for the admin user.
+func scramTLSClient(t *testing.T, address *net.TCPAddr) (*mock.Client, tls.ConnectionState) {
+	t.Helper()
+
+	conn, err := net.Dial("tcp", address.String())
+	require.NoError(t, err)
+	t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+
+	request := make([]byte, 8)
+	binary.BigEndian.PutUint32(request[0:4], 8)
+	binary.BigEndian.PutUint32(request[4:8], uint32(types.VersionSSLRequest))
+	_, err = conn.Write(request)
+	require.NoError(t, err)
+
+	response := make([]byte, 1)
+	_, err = conn.Read(response)
+	require.NoError(t, err)
+	require.Equal(t, sslSupported[0], response[0])
+
+	secure := tls.Client(conn, &tls.Config{InsecureSkipVerify: true}) //nolint:gosec
+	require.NoError(t, secure.Handshake())
+
+	client := mock.NewClient(t, secure)
+	client.HandshakeProtocol(t, types.Version30, "user", "admin")
+	return client, secure.ConnectionState()
+}
+
+// scramAuthenticate performs a SCRAM-SHA-256 exchange using the password
+// "secret" and the given GS2 header and channel binding data.
+func scramAuthenticate(t *testing.T, client *mock.Client, mechanism, gs2Header string, binding []byte) {
+	t.Helper()
+
+	clientFirstBare := "n=,r=rOprNGfwEbeRWgbNEkqO"
+	saslWriteInitialResponse(t, client, mechanism, gs2Header+clientFirstBare)
+
+	require.Equal(t, int32(authSASLContinue), saslReadAuth(t, client))
+	serverFirst := string(client.Msg)
+
+	var nonce, salt string
+	var iterations int
+	for _, attr := range strings.Split(serverFirst, ",") {
+		switch {
+		case strings.HasPrefix(attr, "r="):
+			nonce = attr[2:]
+		case strings.HasPrefix(attr, "s="):
+			salt = attr[2:]
+		case strings.HasPrefix(attr, "i="):
+			_, err := fmt.Sscanf(attr[2:], "%d", &iterations)
+			require.NoError(t, err)
+		}
+	}
+
+	decoded, err := base64.StdEncoding.DecodeString(salt)
+	require.NoError(t, err)
+
+	salted, err := pbkdf2.Key(sha256.New, "secret", decoded, iterations, sha256.Size)
+	require.NoError(t, err)
+
+	cbind := base64.StdEncoding.EncodeToString(append([]byte(gs2Header), binding...))
+	withoutProof := "c=" + cbind + ",r=" + nonce
+	auth := clientFirstBare + "," + serverFirst + "," + withoutProof
+
+	clientKey := scramHMAC(salted, "Client Key")
+	storedKey := sha256.Sum256(clientKey)
+	proof := make([]byte, len(clientKey))
+	subtle.XORBytes(proof, clientKey, scramHMAC(storedKey[:], auth))
+
+	client.Start(types.ClientPassword)
+	client.AddBytes([]byte(withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof)))
+	require.NoError(t, client.End())
+
+}
@@ -3,10 +3,12 @@ package wire
 import (
 	"bytes"
 	"context"
+	"database/sql"
 	"fmt"
 	"strconv"
 	"testing"
 
+	"github.com/jackc/pgx/v5"
 	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
 	"github.com/jeroenrinzema/psql-wire/pkg/types"
 	"github.com/neilotoole/slogt"
@@ -128,3 +130,67 @@ func TestClearTextPasswordIncorrect(t *testing.T) {
 	_, _, err = result.ReadTypedMsg()
 	require.Error(t, err, "Expected no ready for query message after auth failure")
 }
+
+func TestMD5PasswordHash(t *testing.T) {
+	require.Equal(t, "md53175bce1d3201d16594cebf9d7eb3f9d", MD5PasswordHash("postgres", "postgres"))
+	require.True(t, isMD5PasswordHash(MD5PasswordHash("admin", "secret")))
+	require.False(t, isMD5PasswordHash("md5secret"))
+}
+
+func TestMD5Password(t *testing.T) {
+	t.Parallel()
+
+	passwords := map[string]string{
+		"plain":  "secret",
+		"hashed": MD5PasswordHash("hashed", "secret"),
+	}
+
+	lookup := func(ctx context.Context, database, username string) (context.Context, string, error) {
+		return ctx, passwords[username], nil
+	}
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), SessionAuthStrategy(MD5Password(lookup)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	for username := range passwords {
+		t.Run(username, func(t *testing.T) {
+			t.Run("jackc/pgx", func(t *testing.T) {
+				ctx := context.Background()
+				connstr := fmt.Sprintf("postgres://%s:secret@%s:%d", username, address.IP, address.Port)
+				conn, err := pgx.Connect(ctx, connstr)
+				require.NoError(t, err)
+				require.NoError(t, conn.Close(ctx))
+			})
+
+			t.Run("lib/pq", func(t *testing.T) {
+				connstr := fmt.Sprintf("host=%s port=%d user=%s password=secret sslmode=disable", address.IP, address.Port, username)
+				conn, err := sql.Open("postgres", connstr)
+				require.NoError(t, err)
+				require.NoError(t, conn.Ping())
+				require.NoError(t, conn.Close())
+			})
+		})
+	}
+
+	tests := map[string]string{
+		"invalid password": "postgres://plain:incorrect@%s:%d",
+		"unknown user":     "postgres://unknown:secret@%s:%d",
+	}
+
+	for name, connstr := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			_, err := pgx.Connect(ctx, fmt.Sprintf(connstr, address.IP, address.Port))
+			require.Error(t, err)
+			require.Contains(t, err.Error(), "28P01")
+		})
+	}
+}
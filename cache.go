@@ -133,7 +133,12 @@ func (p *Portal) close() {
 
 func portalSuspended(writer *buffer.Writer) error {
 	writer.Start(types.ServerPortalSuspended)
-	return writer.End()
+	err := writer.End()
+	if err != nil {
+		return err
+	}
+
+	return writer.Flush()
 }
 
 func (p *Portal) execute(ctx context.Context, limit Limit, reader *buffer.Reader, writer *buffer.Writer) error {
@@ -0,0 +1,61 @@
+package wire
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"log/slog"
+
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// extendedSecretKeyLength is the length of the secret keys generated for
+// clients using protocol version 3.2 or later, matching the PostgreSQL
+// backend.
+const extendedSecretKeyLength = 32
+
+// generateBackendKeyData generates the backend key data of a new session. The
+// process ID is unique across the sessions served by the server and the
+// secret key is generated using a cryptographically secure random generator.
+// The secret key length depends on the negotiated protocol version.
+func (srv *Server) generateBackendKeyData(ctx context.Context) (int32, []byte) {
+	secretKey := make([]byte, legacySecretKeyLength)
+	if ProtocolVersion(ctx) >= types.Version32 {
+		secretKey = make([]byte, extendedSecretKeyLength)
+	}
+
+	// NOTE: crypto/rand.Read never returns an error and crashes the program
+	// irrecoverably whenever the system random generator fails.
+	rand.Read(secretKey) //nolint:errcheck
+	return srv.sessions.nextPID(), secretKey
+}
+
+// routeCancelRequest cancels the statement executed by the session matching
+// the given process ID and secret key. Cancel requests not matching any
+// session, or targeting an idle session, are ignored. No response is sent
+// back to the client sending the cancel request.
+func (srv *Server) routeCancelRequest(ctx context.Context, processID int32, secretKey []byte) error {
+	session, has := srv.sessions.get(processID)
+	if !has || subtle.ConstantTimeCompare(session.control.secretKey, secretKey) != 1 {
+		srv.logger.Debug("ignoring cancel request not matching any session", slog.Int("pid", int(processID)))
+		return nil
+	}
+
+	if !session.cancel() {
+		srv.logger.Debug("ignoring cancel request for an idle session", slog.Int("pid", int(processID)))
+	}
+
+	return nil
+}
+
+// cancel cancels the statement currently executed by the session. The context
+// passed to the executed statement is canceled and a query_canceled error is
+// reported to the client. False is returned if the session is not executing a
+// statement.
+func (srv *Session) cancel() bool {
+	if srv.activity.snapshot().State != SessionActive {
+		return false
+	}
+
+	return srv.control.interrupt(NewErrQueryCanceled())
+}
@@ -1,9 +1,11 @@
 package wire
 
 import (
+	"bytes"
 	"context"
 	"crypto/rand"
 	"crypto/rsa"
+	"crypto/subtle"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -19,7 +21,12 @@ import (
 	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
-	_ "github.com/lib/pq"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/lib/pq"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 type testServer struct {
@@ -31,7 +38,7 @@ type testServer struct {
 
 type testSession struct {
 	ProcessID int32
-	SecretKey int32
+	SecretKey []byte
 	Cancel    context.CancelFunc
 	Addr      net.Addr
 }
@@ -51,9 +58,16 @@ func newTestServer(tlsConfig *tls.Config) (*testServer, error) {
 	return ts, nil
 }
 
-func (ts *testServer) backendKeyData(ctx context.Context) (int32, int32) {
+func (ts *testServer) backendKeyData(ctx context.Context) (int32, []byte) {
 	rng := mathrand.New(mathrand.NewSource(time.Now().UnixNano()))
-	processID, secretKey := rng.Int31(), rng.Int31()
+	processID := rng.Int31()
+
+	secretKey := make([]byte, 4)
+	if ProtocolVersion(ctx) >= types.Version32 {
+		secretKey = make([]byte, 32)
+	}
+
+	_, _ = rand.Read(secretKey)
 
 	ts.mutex.Lock()
 	ts.sessions[processID] = &testSession{
@@ -66,12 +80,12 @@ func (ts *testServer) backendKeyData(ctx context.Context) (int32, int32) {
 	return processID, secretKey
 }
 
-func (ts *testServer) cancelRequest(ctx context.Context, processID, secretKey int32) error {
+func (ts *testServer) cancelRequest(ctx context.Context, processID int32, secretKey []byte) error {
 	ts.mutex.RLock()
 	session, exists := ts.sessions[processID]
 	ts.mutex.RUnlock()
 
-	if !exists || session.SecretKey != secretKey {
+	if !exists || subtle.ConstantTimeCompare(session.SecretKey, secretKey) != 1 {
 		return nil
 	}
 
@@ -105,6 +119,11 @@ func (ts *testServer) handler(ctx context.Context, query Query) (PreparedStateme
 				return queryCtx.Err()
 			case <-ts.rowChan:
 				_ = writer.Row([]any{i})
+				// NOTE: rows are flushed for the client to be able to read
+				// the row while the handler awaits the next signal.
+				if flusher, ok := writer.(Flusher); ok {
+					_ = flusher.Flush()
+				}
 			}
 		}
 
@@ -247,3 +266,84 @@ func TestCancellationWithTLS(t *testing.T) {
 	port, server := startTestServer(t, true)
 	testCancellation(t, port, server, "require")
 }
+
+func TestCancelRouting(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{}, 1)
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			started <- struct{}{}
+			<-ctx.Done()
+			return ctx.Err()
+		}
+
+		return Prepared(NewStatement(handle)), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), CancelRouting())
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	db, err := sql.Open("postgres", fmt.Sprintf("host=%s port=%d dbname=test user=test sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer db.Close() //nolint:errcheck
+
+	conn, err := db.Conn(context.Background())
+	require.NoError(t, err)
+	defer conn.Close() //nolint:errcheck
+
+	sessions := server.Sessions()
+	require.Len(t, sessions, 1)
+
+	session, has := server.sessions.get(sessions[0].PID)
+	require.True(t, has)
+	assert.Len(t, session.control.secretKey, legacySecretKeyLength)
+
+	// NOTE: cancel requests using an invalid secret key are ignored
+	go func() {
+		<-started
+		invalid := bytes.Clone(session.control.secretKey)
+		invalid[0]++
+		assert.NoError(t, server.routeCancelRequest(context.Background(), sessions[0].PID, invalid))
+		assert.Equal(t, SessionActive, server.Sessions()[0].State)
+		assert.NoError(t, server.routeCancelRequest(context.Background(), sessions[0].PID, session.control.secretKey))
+	}()
+
+	_, err = conn.ExecContext(context.Background(), "SELECT pg_sleep(60)")
+	require.Error(t, err)
+
+	var pqErr *pq.Error
+	require.ErrorAs(t, err, &pqErr)
+	assert.Equal(t, string(codes.QueryCanceled), string(pqErr.Code))
+	assert.Equal(t, "canceling statement due to user request", pqErr.Message)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-started
+		cancel()
+	}()
+
+	// NOTE: the client sends a cancel request once the context is canceled
+	_, err = conn.ExecContext(ctx, "SELECT pg_sleep(60)")
+	require.ErrorAs(t, err, &pqErr)
+	assert.Equal(t, string(codes.QueryCanceled), string(pqErr.Code))
+
+	assert.Equal(t, SessionIdle, server.Sessions()[0].State)
+}
+
+func TestGenerateBackendKeyData(t *testing.T) {
+	t.Parallel()
+
+	server, err := NewServer(nil)
+	require.NoError(t, err)
+
+	pid, secretKey := server.generateBackendKeyData(setProtocolVersion(context.Background(), types.Version30))
+	assert.Equal(t, int32(1), pid)
+	assert.Len(t, secretKey, legacySecretKeyLength)
+
+	pid, secretKey = server.generateBackendKeyData(setProtocolVersion(context.Background(), types.Version32))
+	assert.Equal(t, int32(2), pid)
+	assert.Len(t, secretKey, extendedSecretKeyLength)
+	require.NoError(t, validateSecretKey(types.Version32, secretKey))
+}
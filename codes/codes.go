@@ -122,6 +122,7 @@ var (
 	SchemaAndDataStatementMixingNotSupported        Code = "25007"
 	NoActiveSQLTransaction                          Code = "25P01"
 	InFailedSQLTransaction                          Code = "25P02"
+	IdleInTransactionSessionTimeout                 Code = "25P03"
 	// Section: Class 26 - Invalid SQL Statement Name
 	InvalidSQLStatementName Code = "26000"
 	// Section: Class 27 - Triggered Data Change Violation
@@ -240,6 +241,7 @@ var (
 	CrashShutdown        Code = "57P02"
 	CannotConnectNow     Code = "57P03"
 	DatabaseDropped      Code = "57P04"
+	IdleSessionTimeout   Code = "57P05"
 	// Section: Class 58 - System Error
 	System        Code = "58000"
 	Io            Code = "58030"
@@ -1,7 +1,6 @@
 package wire
 
 import (
-	"bytes"
 	"context"
 	"errors"
 	"fmt"
@@ -9,6 +8,7 @@ import (
 	"log/slog"
 	"net"
 	"strings"
+	"sync"
 
 	"github.com/jeroenrinzema/psql-wire/codes"
 	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
@@ -58,6 +58,10 @@ type Session struct {
 	Portals    PortalCache
 	Attributes map[string]interface{}
 	reader     *buffer.Reader
+	roles      *roleState
+	host       *VirtualHostConfig
+	activity   *sessionActivity
+	control    *sessionControl
 
 	// pipelining
 	ParallelPipeline ParallelPipelineConfig
@@ -74,6 +78,10 @@ type Session struct {
 	// discard messages until it receives a Sync, then respond with
 	// ReadyForQuery.
 	discardUntilSync bool
+
+	// scratch contains the scratch writers which are reused between pipelined
+	// executions to capture their wire output.
+	scratch sync.Pool
 }
 
 // isExtendedQueryMessage returns true for message types that belong to the
@@ -89,6 +97,18 @@ func isExtendedQueryMessage(t types.ClientMessage) bool {
 	}
 }
 
+// isStatementMessage returns true for message types which start the statement
+// timeout, following the PostgreSQL backend.
+func isStatementMessage(t types.ClientMessage) bool {
+	switch t {
+	case types.ClientSimpleQuery, types.ClientParse, types.ClientBind,
+		types.ClientDescribe, types.ClientExecute:
+		return true
+	default:
+		return false
+	}
+}
+
 // consumeCommands consumes incoming commands sent over the Postgres wire connection.
 // Commands consumed from the connection are returned through a go channel.
 // Responses for the given message type are written back to the client.
@@ -108,16 +128,47 @@ func (srv *Session) consumeCommands(ctx context.Context, conn net.Conn, reader *
 	}
 
 	defer srv.Close()
+	defer srv.control.end()
 
 	for {
-		if err = srv.consumeSingleCommand(ctx, reader, writer, conn); err != nil {
+		err = srv.consumeSingleCommand(ctx, reader, writer, conn)
+		if err != nil && srv.control.err() != nil {
+			// NOTE: sessions terminated by the server are treated as if the
+			// client has terminated the connection.
+			return srv.handleConnTerminate(ctx)
+		}
+
+		if err != nil {
 			return err
 		}
 	}
 }
 
 func (srv *Session) consumeSingleCommand(ctx context.Context, reader *buffer.Reader, writer *buffer.Writer, conn net.Conn) error {
+	// NOTE: pending messages are flushed before awaiting new commands from the
+	// client. Messages are kept buffered whenever the client has already sent
+	// (pipelined) commands which could be consumed without blocking.
+	if reader.Buffered() == 0 {
+		err := writer.Flush()
+		if err != nil {
+			return err
+		}
+	}
+
+	err := srv.waitForCommand()
+	if err != nil {
+		return err
+	}
+
 	t, length, err := reader.ReadTypedMsg()
+	if rerr := srv.control.resume(err); rerr != nil {
+		return rerr
+	}
+
+	if terminated := srv.control.err(); terminated != nil {
+		return srv.writeTerminated(writer, terminated)
+	}
+
 	if err == io.EOF {
 		return err
 	}
@@ -140,16 +191,38 @@ func (srv *Session) consumeSingleCommand(ctx context.Context, reader *buffer.Rea
 
 	// We hold closingMu for reading while checking closing + adding to the
 	// wait group, so that Close cannot finish wg.Wait before we are tracked.
+	//
+	// NOTE: sessions continue to be served during a smart shutdown. These
+	// sessions are tracked by the session registry until they are closed.
 	srv.closingMu.RLock()
-	if srv.closing.Load() {
+	closing := srv.closing.Load()
+	if closing && !srv.draining.Load() {
 		srv.closingMu.RUnlock()
 		return nil
 	}
-	srv.wg.Add(1)
+	if !closing {
+		srv.wg.Add(1)
+		defer srv.wg.Done()
+	}
 	srv.closingMu.RUnlock()
 	srv.logger.Debug("<- incoming command", slog.Int("length", length), slog.String("type", t.String()))
+	ctx = srv.control.begin(ctx)
+	if isStatementMessage(t) {
+		srv.control.startTimer(srv.statementTimeout(), NewErrStatementTimeout())
+	}
+
 	err = srv.handleCommand(ctx, conn, t, reader, writer)
-	srv.wg.Done()
+
+	// NOTE: large message buffers are released once the command has been
+	// handled to avoid retaining them while the session is idle.
+	reader.Release()
+
+	// NOTE: the statement timeout is stopped once a Execute has completed,
+	// pipelined executions are covered until the next Sync.
+	if t == types.ClientExecute && !srv.ParallelPipeline.Enabled {
+		srv.control.stopTimer()
+	}
+
 	if errors.Is(err, io.EOF) {
 		return nil
 	}
@@ -262,6 +335,10 @@ func (srv *Session) handleCommand(ctx context.Context, conn net.Conn, t types.Cl
 			}
 		}
 
+		if err := writer.Flush(); err != nil {
+			return err
+		}
+
 		if srv.FlushConn != nil {
 			return srv.FlushConn(ctx)
 		}
@@ -294,7 +371,7 @@ func (srv *Session) handleCommand(ctx context.Context, conn net.Conn, t types.Cl
 }
 
 func (srv *Session) handleSimpleQuery(ctx context.Context, reader *buffer.Reader, writer *buffer.Writer) error {
-	if srv.parse == nil {
+	if srv.parseFn() == nil {
 		return srv.WriteError(ctx, writer, NewErrUnimplementedMessageType(types.ClientSimpleQuery))
 	}
 
@@ -304,6 +381,7 @@ func (srv *Session) handleSimpleQuery(ctx context.Context, reader *buffer.Reader
 	}
 
 	srv.logger.Debug("incoming simple query", slog.String("query", query))
+	srv.activity.query(query)
 
 	// NOTE: If a completely empty (no contents other than whitespace) query
 	// string is received, the response is EmptyQueryResponse followed by
@@ -319,7 +397,7 @@ func (srv *Session) handleSimpleQuery(ctx context.Context, reader *buffer.Reader
 		return srv.readyForQuery(ctx, writer)
 	}
 
-	statements, err := srv.parse(ctx, Query{Query: query, SimpleQuery: true})
+	statements, err := srv.parseQuery(ctx, Query{Query: query, SimpleQuery: true})
 	if err != nil {
 		return srv.WriteError(ctx, writer, err)
 	}
@@ -351,7 +429,7 @@ func (srv *Session) handleSimpleQuery(ctx context.Context, reader *buffer.Reader
 }
 
 func (srv *Session) handleParse(ctx context.Context, reader *buffer.Reader, writer *buffer.Writer) error {
-	if srv.parse == nil || srv.Statements == nil {
+	if srv.parseFn() == nil || srv.Statements == nil {
 		err := NewErrUnimplementedMessageType(types.ClientParse)
 		if srv.ParallelPipeline.Enabled {
 			return srv.drainQueueAndWriteError(ctx, writer, err)
@@ -369,6 +447,8 @@ func (srv *Session) handleParse(ctx context.Context, reader *buffer.Reader, writ
 		return err
 	}
 
+	srv.activity.query(query)
+
 	// NOTE: the number of parameter data types specified (can be
 	// zero). Note that this is not an indication of the number of parameters
 	// that might appear in the query string, only the number that the frontend
@@ -407,7 +487,7 @@ func (srv *Session) handleParse(ctx context.Context, reader *buffer.Reader, writ
 		return srv.parsePipelined(ctx, writer, name, query, parameterOIDs)
 	}
 
-	statement, err := singleStatement(srv.parse(ctx, Query{Query: query, ParameterOIDs: parameterOIDs}))
+	statement, err := singleStatement(srv.parseQuery(ctx, Query{Query: query, ParameterOIDs: parameterOIDs}))
 	if err != nil {
 		return srv.WriteError(ctx, writer, err)
 	}
@@ -425,7 +505,7 @@ func (srv *Session) handleParse(ctx context.Context, reader *buffer.Reader, writ
 
 // parsePipelined handles Parse in parallel pipeline mode
 func (srv *Session) parsePipelined(ctx context.Context, writer *buffer.Writer, name, query string, parameterOIDs []uint32) error {
-	statement, err := singleStatement(srv.parse(ctx, Query{Query: query, ParameterOIDs: parameterOIDs}))
+	statement, err := singleStatement(srv.parseQuery(ctx, Query{Query: query, ParameterOIDs: parameterOIDs}))
 	if err != nil {
 		return srv.drainQueueAndWriteError(ctx, writer, err)
 	}
@@ -722,6 +802,7 @@ func (srv *Session) handleExecute(ctx context.Context, reader *buffer.Reader, wr
 	}
 
 	srv.logger.Debug("executing", slog.String("name", name), slog.Uint64("limit", uint64(limit)))
+	srv.activity.active()
 
 	if srv.ParallelPipeline.Enabled {
 		return srv.executePipelined(ctx, writer, name, limit)
@@ -819,12 +900,10 @@ func (srv *Session) executeAsync(ctx context.Context, done chan struct{}, portal
 
 	srv.logger.Debug("starting async execution")
 
-	buf := &bytes.Buffer{}
-	w := buffer.NewWriter(srv.logger, buf)
-
-	err := portal.execute(ctx, limit, srv.reader, w)
+	scratch := srv.scratchWriter()
+	err := portal.execute(ctx, limit, srv.reader, scratch.writer)
 
-	result := &executeResult{buf: buf, err: err}
+	result := &executeResult{buf: &scratch.buf, err: err, scratch: scratch}
 
 	srv.logger.Debug("async execution complete",
 		slog.Bool("has_error", err != nil))
@@ -978,6 +1057,7 @@ func (srv *Session) writeQueuedResponse(ctx context.Context, writer *buffer.Writ
 		}
 
 		_, err := writer.Write(event.Result.buf.Bytes())
+		srv.releaseScratch(event.Result.scratch)
 		return err
 
 	default:
@@ -6,6 +6,7 @@ import (
 	"errors"
 	"fmt"
 	"net"
+	"strings"
 	"sync"
 	"testing"
 
@@ -45,6 +46,38 @@ func TestMessageSizeExceeded(t *testing.T) {
 	client.Close(t)
 }
 
+func TestReadBufferSize(t *testing.T) {
+	t.Parallel()
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete(fmt.Sprintf("SELECT %d", len(query.Query)))
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), ReadBufferSize(64), MaxMessageSize(1<<16))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	conn, err := pgx.Connect(context.Background(), fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(context.Background()) //nolint:errcheck
+
+	// NOTE: messages exceeding the read buffer size are accepted as long as
+	// they do not exceed the maximum message size.
+	query := "SELECT " + strings.Repeat("a", 1<<15)
+	result, err := conn.Exec(context.Background(), query)
+	require.NoError(t, err)
+	assert.Equal(t, int64(len(query)), result.RowsAffected())
+
+	_, err = conn.Exec(context.Background(), "SELECT "+strings.Repeat("a", 1<<17))
+	require.ErrorContains(t, err, "bigger than maximum allowed")
+
+	result, err = conn.Exec(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+	assert.Equal(t, int64(8), result.RowsAffected())
+}
+
 func TestBindMessageParameters(t *testing.T) {
 	t.Parallel()
 
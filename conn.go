@@ -2,9 +2,12 @@ package wire
 
 import (
 	"context"
+	"crypto/tls"
+	"crypto/x509"
 	"net"
 
 	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
 )
 
 type ctxKey int
@@ -14,6 +17,15 @@ const (
 	ctxClientMetadata
 	ctxServerMetadata
 	ctxRemoteAddr
+	ctxTLSState
+	ctxServerCertificate
+	ctxSASLMechanisms
+	ctxHBARule
+	ctxPeerCred
+	ctxRole
+	ctxProtocolVersion
+	ctxVirtualHost
+	ctxProxyHeader
 )
 
 // setTypeInfo constructs a new Postgres type connection info for the given value
@@ -110,3 +122,170 @@ func ServerParameters(ctx context.Context) Parameters {
 
 	return val.(Parameters)
 }
+
+// setSecureConn constructs a new context containing the negotiated TLS
+// connection state and the certificate presented to the client.
+func setSecureConn(ctx context.Context, conn *secureConn) context.Context {
+	state := conn.ConnectionState()
+	ctx = context.WithValue(ctx, ctxTLSState, &state)
+	return context.WithValue(ctx, ctxServerCertificate, conn.certificate)
+}
+
+// TLSConnectionState returns the negotiated TLS connection state if the
+// connection has been upgraded to a secure connection. Nil is returned for
+// insecure connections.
+func TLSConnectionState(ctx context.Context) *tls.ConnectionState {
+	val := ctx.Value(ctxTLSState)
+	if val == nil {
+		return nil
+	}
+
+	return val.(*tls.ConnectionState)
+}
+
+// PeerCertificates returns the verified certificate chain presented by the
+// client during the TLS handshake. The first element is the leaf certificate.
+// Nil is returned for insecure connections or when the client has not
+// presented a verified certificate.
+func PeerCertificates(ctx context.Context) []*x509.Certificate {
+	state := TLSConnectionState(ctx)
+	if state == nil || len(state.VerifiedChains) == 0 {
+		return nil
+	}
+
+	return state.VerifiedChains[0]
+}
+
+// serverCertificate returns the certificate presented to the client during
+// the TLS handshake if it has been set inside the given context.
+func serverCertificate(ctx context.Context) *tls.Certificate {
+	val := ctx.Value(ctxServerCertificate)
+	if val == nil {
+		return nil
+	}
+
+	return val.(*tls.Certificate)
+}
+
+// setSASLMechanisms constructs a new context containing the SASL mechanisms
+// announced to the client.
+func setSASLMechanisms(ctx context.Context, mechanisms []string) context.Context {
+	return context.WithValue(ctx, ctxSASLMechanisms, mechanisms)
+}
+
+// SASLMechanisms returns the names of the SASL mechanisms announced to the
+// client during authentication. Nil is returned if no SASL mechanisms have
+// been announced.
+func SASLMechanisms(ctx context.Context) []string {
+	val := ctx.Value(ctxSASLMechanisms)
+	if val == nil {
+		return nil
+	}
+
+	return val.([]string)
+}
+
+// setHBARule constructs a new context containing the host based
+// authentication rule matched by the connection.
+func setHBARule(ctx context.Context, rule *HBARule) context.Context {
+	return context.WithValue(ctx, ctxHBARule, rule)
+}
+
+// MatchedHBARule returns the host based authentication rule matched by the
+// connection if it has been set inside the given context. Strategies could
+// use the rule options to alter their behaviour.
+func MatchedHBARule(ctx context.Context) *HBARule {
+	val := ctx.Value(ctxHBARule)
+	if val == nil {
+		return nil
+	}
+
+	return val.(*HBARule)
+}
+
+// setPeerCredentials constructs a new context containing the credentials of
+// the process connected through a Unix domain socket.
+func setPeerCredentials(ctx context.Context, cred *PeerCred) context.Context {
+	return context.WithValue(ctx, ctxPeerCred, cred)
+}
+
+// PeerCredentials returns the operating system credentials of the process
+// connected through a Unix domain socket. Nil is returned for connections
+// which have not been made through a Unix domain socket or when the
+// credentials could not be retrieved on the current platform.
+func PeerCredentials(ctx context.Context) *PeerCred {
+	val := ctx.Value(ctxPeerCred)
+	if val == nil {
+		return nil
+	}
+
+	return val.(*PeerCred)
+}
+
+// setProtocolVersion constructs a new context containing the protocol version
+// negotiated with the client.
+func setProtocolVersion(ctx context.Context, version types.Version) context.Context {
+	return context.WithValue(ctx, ctxProtocolVersion, version)
+}
+
+// ProtocolVersion returns the protocol version negotiated with the client. The
+// negotiated version is the older of the version requested by the client and
+// [types.VersionLatest]. Zero is returned if no version has been negotiated yet.
+func ProtocolVersion(ctx context.Context) types.Version {
+	val := ctx.Value(ctxProtocolVersion)
+	if val == nil {
+		return 0
+	}
+
+	return val.(types.Version)
+}
+
+// ServerName returns the server name (SNI) sent by the client during the TLS
+// handshake. An empty string is returned for insecure connections or when the
+// client has not sent a server name.
+func ServerName(ctx context.Context) string {
+	state := TLSConnectionState(ctx)
+	if state == nil {
+		return ""
+	}
+
+	return state.ServerName
+}
+
+// setVirtualHost constructs a new context containing the virtual host matched
+// by the server name sent by the client.
+func setVirtualHost(ctx context.Context, host *VirtualHostConfig) context.Context {
+	return context.WithValue(ctx, ctxVirtualHost, host)
+}
+
+// MatchedVirtualHost returns the virtual host matched by the server name sent
+// by the client during the TLS handshake. Nil is returned if no virtual host
+// has been matched.
+func MatchedVirtualHost(ctx context.Context) *VirtualHostConfig {
+	val := ctx.Value(ctxVirtualHost)
+	if val == nil {
+		return nil
+	}
+
+	return val.(*VirtualHostConfig)
+}
+
+// setProxyHeader constructs a new context containing the PROXY protocol header
+// sent by a trusted proxy.
+func setProxyHeader(ctx context.Context, header *ProxyHeader) context.Context {
+	return context.WithValue(ctx, ctxProxyHeader, header)
+}
+
+// ProxyProtocolHeader returns the PROXY protocol header sent by the trusted
+// proxy which established the connection. The header contains the original
+// source and destination addresses together with any TLV fields. Nil is
+// returned for connections which have not been established through a trusted
+// proxy.
+func ProxyProtocolHeader(ctx context.Context) *ProxyHeader {
+	val := ctx.Value(ctxProxyHeader)
+	if val == nil {
+		return nil
+	}
+
+	return val.(*ProxyHeader)
+}
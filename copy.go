@@ -30,7 +30,6 @@ func NewCopyReader(session *Session, reader *buffer.Reader, writer *buffer.Write
 		session: session,
 		writer:  writer,
 		columns: columns, // NOTE: the columns are only used to determine the format of the data that is read from the reader.
-		chunk:   make([]byte, reader.MaxMessageSize),
 	}
 }
 
@@ -39,7 +38,6 @@ type CopyReader struct {
 	session *Session
 	writer  *buffer.Writer
 	columns Columns
-	chunk   []byte
 }
 
 // Columns returns the columns that are currently defined within the copy reader.
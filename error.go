@@ -2,6 +2,7 @@ package wire
 
 import (
 	"context"
+	"errors"
 
 	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
 	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
@@ -82,6 +83,14 @@ func WriteUnterminatedError(writer *buffer.Writer, err error) error {
 // ErrorResponse and sets `discardUntilSync` (ReadyForQuery comes from Sync).
 // In simple query mode it writes ErrorResponse + ReadyForQuery.
 func (srv *Session) WriteError(ctx context.Context, writer *buffer.Writer, err error) error {
+	// NOTE: statements interrupted through the session control return the
+	// context error, the cause of the interruption is reported instead.
+	if errors.Is(err, context.Canceled) {
+		if cause := context.Cause(ctx); cause != nil && !errors.Is(cause, context.Canceled) {
+			err = cause
+		}
+	}
+
 	if werr := WriteUnterminatedError(writer, err); werr != nil {
 		return werr
 	}
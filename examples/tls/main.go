@@ -24,7 +24,7 @@ func run() error {
 	}
 
 	config := &tls.Config{Certificates: []tls.Certificate{cert}}
-	server, err := wire.NewServer(handler, wire.TLSConfig(config), wire.Logger(logger), wire.MessageBufferSize(100))
+	server, err := wire.NewServer(handler, wire.TLSConfig(config), wire.Logger(logger), wire.MaxMessageSize(100))
 	if err != nil {
 		return err
 	}
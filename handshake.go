@@ -1,6 +1,7 @@
 package wire
 
 import (
+	"bytes"
 	"context"
 	"crypto/tls"
 	"errors"
@@ -16,15 +17,40 @@ import (
 	"github.com/jeroenrinzema/psql-wire/pkg/types"
 )
 
+// newReader constructs a new message reader for the given connection using the
+// configured read buffer and maximum message sizes.
+func (srv *Server) newReader(conn net.Conn) *buffer.Reader {
+	return buffer.NewReaderSize(srv.logger, conn, srv.ReadBufferSize, srv.BufferedMsgSize)
+}
+
 // Handshake performs the connection handshake and returns the connection
 // version and a buffered reader to read incoming messages send by the client.
 func (srv *Server) Handshake(conn net.Conn) (_ net.Conn, version types.Version, reader *buffer.Reader, err error) {
-	reader = buffer.NewReader(srv.logger, conn, srv.BufferedMsgSize)
+	reader = srv.newReader(conn)
+
+	direct, err := srv.isDirectTLS(reader)
+	if err != nil {
+		return conn, version, reader, err
+	}
+
+	if direct {
+		conn, reader, err = srv.directConnUpgrade(conn, reader)
+		if err != nil {
+			return conn, version, reader, err
+		}
+	}
+
 	version, err = srv.readVersion(reader)
 	if err != nil {
 		return conn, version, reader, err
 	}
 
+	// NOTE: the connection has already been encrypted, any further encryption
+	// requests are a protocol violation.
+	if direct && (version == types.VersionSSLRequest || version == types.VersionGSSENC) {
+		return conn, version, reader, errors.New("unexpected encryption request over a direct TLS connection")
+	}
+
 	// TODO: support GSS encryption
 	//
 	// `psql-wire` currently does not support GSS encrypted connections. The GSS
@@ -41,9 +67,11 @@ func (srv *Server) Handshake(conn net.Conn) (_ net.Conn, version types.Version,
 		return srv.Handshake(conn)
 	}
 
-	conn, reader, version, err = srv.potentialConnUpgrade(conn, reader, version)
-	if err != nil {
-		return conn, version, reader, err
+	if !direct {
+		conn, reader, version, err = srv.potentialConnUpgrade(conn, reader, version)
+		if err != nil {
+			return conn, version, reader, err
+		}
 	}
 
 	if version == types.VersionCancel {
@@ -89,24 +117,29 @@ func (srv *Server) readVersion(reader *buffer.Reader) (_ types.Version, err erro
 
 // readCancelRequest reads the cancel request parameters (processID and secretKey)
 // from the client connection. The full cancel request format is:
-// Int32(16) - Length of message contents in bytes, including self
+// Int32 - Length of message contents in bytes, including self
 // Int32(80877102) - The cancel request code (already read as version)
 // Int32 - The process ID of the target backend
-// Int32 - The secret key for the target backend
+// Byten - The secret key for the target backend
 // The first two fields are already handled by readVersion, so this only needs
-// to read the last two fields.
-func (srv *Server) readCancelRequest(reader *buffer.Reader) (processID int32, secretKey int32, err error) {
+// to read the last two fields. The secret key is 4 bytes long for protocol
+// versions prior to 3.2 and up to 256 bytes long since protocol version 3.2.
+func (srv *Server) readCancelRequest(reader *buffer.Reader) (processID int32, secretKey []byte, err error) {
 	processID, err = reader.GetInt32()
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to read process ID from cancel request: %w", err)
+		return 0, nil, fmt.Errorf("failed to read process ID from cancel request: %w", err)
+	}
+
+	if len(reader.Msg) < legacySecretKeyLength || len(reader.Msg) > MaxSecretKeyLength {
+		return 0, nil, fmt.Errorf("invalid secret key length %d in cancel request", len(reader.Msg))
 	}
 
-	secretKey, err = reader.GetInt32()
+	secretKey, err = reader.GetBytes(len(reader.Msg))
 	if err != nil {
-		return 0, 0, fmt.Errorf("failed to read secret key from cancel request: %w", err)
+		return 0, nil, fmt.Errorf("failed to read secret key from cancel request: %w", err)
 	}
 
-	return processID, secretKey, nil
+	return processID, bytes.Clone(secretKey), nil
 }
 
 // readyForQuery writes a ReadyForQuery message with the transaction status
@@ -123,12 +156,26 @@ func (srv *Session) readyForQuery(ctx context.Context, writer *buffer.Writer) er
 		srv.Portals.Close()
 	}
 
+	if srv.roles != nil {
+		err := srv.roles.flush(writer)
+		if err != nil {
+			return err
+		}
+	}
+
+	srv.activity.ready(status)
+
+	srv.control.ready(status)
+
 	writer.Start(types.ServerReady)
 	writer.AddByte(byte(status))
 	if err := writer.End(); err != nil {
 		return err
 	}
-	return nil
+
+	// NOTE: the client awaits the ReadyForQuery message before sending any
+	// new commands, all buffered messages have to be delivered.
+	return writer.Flush()
 }
 
 // txStatus returns the byte that should be written into the next ReadyForQuery
@@ -271,6 +318,10 @@ func (srv *Server) writeParameters(ctx context.Context, writer *buffer.Writer, p
 	if srv.Version != "" {
 		params[ParamServerVersion] = srv.Version
 	}
+	if role := AuthenticatedRole(ctx); role != nil {
+		maps.Copy(params, role.Settings)
+	}
+
 	params[ParamIsSuperuser] = buffer.EncodeBoolean(IsSuperUser(ctx))
 	params[ParamSessionAuthorization] = AuthenticatedUsername(ctx)
 	params[ParamServerVersion] = fmt.Sprintf("%d", 15*10000) // 15.1.2 => 15*10000 + 1*100 + 2*1 => 15102
@@ -307,7 +358,8 @@ func (srv *Server) potentialConnUpgrade(conn net.Conn, reader *buffer.Reader, ve
 
 	srv.logger.Debug("attempting to upgrade the client to a TLS connection")
 
-	if srv.TLSConfig == nil || len(srv.TLSConfig.Certificates) == 0 {
+	config := srv.tlsConfig()
+	if !hasCertificates(config) {
 		if srv.ClientAuth == tls.RequireAndVerifyClientCert {
 			srv.logger.Warn("server mandates TLS, but does not possess the requisite certificates")
 			return conn, reader, version, fmt.Errorf("server mandates TLS, but does not possess the requisite certificates")
@@ -324,8 +376,8 @@ func (srv *Server) potentialConnUpgrade(conn net.Conn, reader *buffer.Reader, ve
 
 	// NOTE: initialize the TLS connection and construct a new buffered
 	// reader for the constructed TLS connection.
-	conn = tls.Server(conn, srv.TLSConfig)
-	reader = buffer.NewReader(srv.logger, conn, srv.BufferedMsgSize)
+	conn = newSecureConn(conn, config)
+	reader = srv.newReader(conn)
 
 	version, err = srv.readVersion(reader)
 	if err != nil {
@@ -336,6 +388,53 @@ func (srv *Server) potentialConnUpgrade(conn net.Conn, reader *buffer.Reader, ve
 	return conn, reader, version, err
 }
 
+// isDirectTLS checks whether the client initiated a TLS handshake directly
+// without sending a SSLRequest first (sslnegotiation=direct). The first byte
+// of a startup message is part of the message length while a TLS ClientHello
+// always starts with the TLS handshake record type.
+func (srv *Server) isDirectTLS(reader *buffer.Reader) (bool, error) {
+	peeker, ok := reader.Buffer.(interface{ Peek(n int) ([]byte, error) })
+	if !ok {
+		return false, nil
+	}
+
+	header, err := peeker.Peek(1)
+	if err != nil {
+		return false, err
+	}
+
+	return header[0] == tlsHandshakeRecord, nil
+}
+
+// directConnUpgrade completes a TLS handshake initiated directly by the
+// client. Clients connecting using direct TLS are required to negotiate the
+// postgresql ALPN protocol to prevent cross-protocol attacks.
+func (srv *Server) directConnUpgrade(conn net.Conn, reader *buffer.Reader) (_ net.Conn, _ *buffer.Reader, err error) {
+	srv.logger.Debug("client initiated a direct TLS connection")
+
+	config := srv.tlsConfig()
+	if !hasCertificates(config) {
+		srv.logger.Warn("client initiated a direct TLS connection, but the server does not possess the requisite certificates")
+		return conn, reader, errors.New("direct TLS connection requested, but the server does not possess the requisite certificates")
+	}
+
+	// NOTE: the bytes already buffered by the reader have to be consumed by
+	// the TLS server before reading from the underlying connection.
+	secure := newSecureConn(&bufferedConn{Conn: conn, reader: reader.Buffer}, config)
+	err = secure.Handshake()
+	if err != nil {
+		return secure, reader, err
+	}
+
+	if secure.ConnectionState().NegotiatedProtocol != alpnProtocol {
+		srv.logger.Warn("client initiated a direct TLS connection without the postgresql ALPN protocol")
+		return secure, reader, errors.New("received direct TLS connection request without ALPN protocol negotiation")
+	}
+
+	srv.logger.Debug("direct TLS connection has been established successfully")
+	return secure, srv.newReader(secure), nil
+}
+
 // sslUnsupported announces to the PostgreSQL client that we are unable to
 // upgrade the connection to a secure connection at this time. The client
 // version is read again once the insecure connection has been announced.
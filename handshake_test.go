@@ -1,6 +1,7 @@
 package wire
 
 import (
+	"bytes"
 	"context"
 	"net"
 	"testing"
@@ -41,18 +42,23 @@ func TestProtocolVersionNegotiation(t *testing.T) {
 			params:          []string{"user", "mock"},
 			expectNegotiate: false,
 		},
-		"newer minor version": {
+		"latest version without options": {
 			version:         types.Version32,
 			params:          []string{"user", "mock"},
+			expectNegotiate: false,
+		},
+		"newer minor version": {
+			version:         types.NewVersion(3, 3),
+			params:          []string{"user", "mock"},
 			expectNegotiate: true,
-			expectVersion:   types.Version30,
+			expectVersion:   types.Version32,
 			expectOptions:   []string{},
 		},
 		"grease version with protocol option": {
 			version:         versionGrease,
 			params:          []string{"user", "mock", "_pq_.test_protocol_negotiation", ""},
 			expectNegotiate: true,
-			expectVersion:   types.Version30,
+			expectVersion:   types.Version32,
 			expectOptions:   []string{"_pq_.test_protocol_negotiation"},
 		},
 		"current version with unrecognized protocol option": {
@@ -121,3 +127,110 @@ func TestUnsupportedProtocolMajorVersion(t *testing.T) {
 		})
 	}
 }
+
+func TestExtendedCancelKeys(t *testing.T) {
+	t.Parallel()
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	keyData := func(ctx context.Context) (int32, []byte) {
+		if ProtocolVersion(ctx) >= types.Version32 {
+			return 42, bytes.Repeat([]byte{0xAB}, MaxSecretKeyLength)
+		}
+
+		return 42, []byte{1, 2, 3, 4}
+	}
+
+	type cancelRequest struct {
+		processID int32
+		secretKey []byte
+	}
+
+	requests := make(chan cancelRequest, 1)
+	cancel := func(ctx context.Context, processID int32, secretKey []byte) error {
+		requests <- cancelRequest{processID: processID, secretKey: secretKey}
+		return nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), BackendKeyData(keyData), CancelRequest(cancel))
+	require.NoError(t, err)
+	address := TListenAndServe(t, server)
+
+	tests := map[string]struct {
+		version   types.Version
+		keyLength int
+	}{
+		"protocol 3.0": {
+			version:   types.Version30,
+			keyLength: 4,
+		},
+		"protocol 3.2": {
+			version:   types.Version32,
+			keyLength: MaxSecretKeyLength,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			conn, err := net.Dial("tcp", address.String())
+			require.NoError(t, err)
+
+			client := mock.NewClient(t, conn)
+			client.HandshakeProtocol(t, test.version, "user", "mock")
+			client.Authenticate(t)
+
+			processID, secretKey := client.BackendKeyData(t)
+			require.Equal(t, int32(42), processID)
+			require.Len(t, secretKey, test.keyLength)
+
+			client.ReadyForQuery(t, types.ServerIdle)
+
+			cancelConn, err := net.Dial("tcp", address.String())
+			require.NoError(t, err)
+
+			canceler := mock.NewClient(t, cancelConn)
+			canceler.CancelRequest(t, processID, secretKey)
+
+			request := <-requests
+			require.Equal(t, processID, request.processID)
+			require.Equal(t, secretKey, request.secretKey)
+
+			canceler.Close(t)
+			client.Close(t)
+		})
+	}
+}
+
+func TestValidateSecretKey(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		version types.Version
+		length  int
+		valid   bool
+	}{
+		"protocol 3.0 legacy key":   {version: types.Version30, length: 4, valid: true},
+		"protocol 3.0 extended key": {version: types.Version30, length: 32, valid: false},
+		"protocol 3.2 legacy key":   {version: types.Version32, length: 4, valid: true},
+		"protocol 3.2 extended key": {version: types.Version32, length: 32, valid: true},
+		"protocol 3.2 maximum key":  {version: types.Version32, length: MaxSecretKeyLength, valid: true},
+		"protocol 3.2 too long key": {version: types.Version32, length: MaxSecretKeyLength + 1, valid: false},
+		"protocol 3.2 too short":    {version: types.Version32, length: 2, valid: false},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			err := validateSecretKey(test.version, make([]byte, test.length))
+			if test.valid {
+				require.NoError(t, err)
+				return
+			}
+
+			require.Error(t, err)
+		})
+	}
+}
@@ -0,0 +1,535 @@
+package wire
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"maps"
+	"net"
+	"regexp"
+	"slices"
+	"strings"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+)
+
+// HBAConnType represents the connection type matched by a host based
+// authentication rule.
+type HBAConnType string
+
+const (
+	// HBALocal matches connections made using Unix domain sockets.
+	HBALocal HBAConnType = "local"
+	// HBAHost matches all TCP/IP connections, both plain and TLS encrypted.
+	HBAHost HBAConnType = "host"
+	// HBAHostSSL matches TCP/IP connections encrypted using TLS.
+	HBAHostSSL HBAConnType = "hostssl"
+	// HBAHostNoSSL matches TCP/IP connections which are not encrypted.
+	HBAHostNoSSL HBAConnType = "hostnossl"
+)
+
+// Built-in host based authentication methods which do not have to be
+// configured when constructing a [HostBasedAuth] strategy.
+const (
+	// HBATrust allows the connection unconditionally.
+	HBATrust = "trust"
+	// HBAReject rejects the connection unconditionally.
+	HBAReject = "reject"
+)
+
+// HBARule represents a single host based authentication rule as defined
+// inside a pg_hba.conf file. Database and user names are stored as written
+// inside the rule, quoted names are matched literally while unquoted names
+// could be keywords (all, sameuser, replication) or regular expressions when
+// prefixed with a slash.
+type HBARule struct {
+	// Line is the line number of the rule inside the parsed file.
+	Line      int
+	Type      HBAConnType
+	Databases []string
+	Users     []string
+	// Address contains the client address range matched by the rule. A nil
+	// address matches all client addresses.
+	Address *net.IPNet
+	Method  string
+	Options map[string]string
+}
+
+// String returns the rule formatted using the pg_hba.conf syntax.
+func (rule HBARule) String() string {
+	fields := []string{string(rule.Type), strings.Join(rule.Databases, ","), strings.Join(rule.Users, ",")}
+	if rule.Type != HBALocal {
+		address := "all"
+		if rule.Address != nil {
+			address = rule.Address.String()
+		}
+
+		fields = append(fields, address)
+	}
+
+	fields = append(fields, rule.Method)
+	for _, key := range slices.Sorted(maps.Keys(rule.Options)) {
+		fields = append(fields, key+"="+rule.Options[key])
+	}
+
+	return strings.Join(fields, " ")
+}
+
+// ParseHBA parses the host based authentication rules defined inside the
+// given reader using the pg_hba.conf syntax. Comments, quoted names, comma
+// separated lists, line continuations, CIDR masks and separate IP masks are
+// supported. Host names, samehost/samenet, group (+) and file (@) references
+// and include directives are not supported and result in an error.
+func ParseHBA(reader io.Reader) ([]HBARule, error) {
+	scanner := bufio.NewScanner(reader)
+	rules := []HBARule{}
+
+	var line string
+	var start, number int
+	for scanner.Scan() {
+		number++
+		if line == "" {
+			start = number
+		}
+
+		// NOTE: a backslash at the end of a line continues the rule on the
+		// next line.
+		text := scanner.Text()
+		if strings.HasSuffix(text, "\\") {
+			line += strings.TrimSuffix(text, "\\")
+			continue
+		}
+
+		line += text
+		fields, err := tokenizeHBALine(line)
+		line = ""
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", start, err)
+		}
+
+		if len(fields) == 0 {
+			continue
+		}
+
+		rule, err := parseHBARule(fields)
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", start, err)
+		}
+
+		rule.Line = start
+		rules = append(rules, rule)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if line != "" {
+		return nil, fmt.Errorf("line %d: unexpected end of file after line continuation", start)
+	}
+
+	return rules, nil
+}
+
+// tokenizeHBALine splits the given line into fields. Each field contains one
+// or more comma separated tokens, quotes are preserved inside the tokens.
+func tokenizeHBALine(line string) ([][]string, error) {
+	var fields [][]string
+	var field []string
+	var token strings.Builder
+	var quoted, pending bool
+
+	endToken := func() {
+		if pending {
+			field = append(field, token.String())
+		}
+
+		token.Reset()
+		pending = false
+	}
+
+	endField := func() {
+		endToken()
+		if len(field) > 0 {
+			fields = append(fields, field)
+		}
+
+		field = nil
+	}
+
+	for _, r := range line {
+		switch {
+		case r == '"':
+			quoted = !quoted
+			pending = true
+			token.WriteRune(r)
+		case quoted:
+			token.WriteRune(r)
+		case r == '#':
+			endField()
+			return fields, nil
+		case r == ' ', r == '\t', r == '\r':
+			endField()
+		case r == ',':
+			endToken()
+		default:
+			pending = true
+			token.WriteRune(r)
+		}
+	}
+
+	if quoted {
+		return nil, errors.New("unterminated quoted string")
+	}
+
+	endField()
+	return fields, nil
+}
+
+// parseHBARule parses a single rule out of the given fields.
+func parseHBARule(fields [][]string) (HBARule, error) {
+	single := func(field []string, name string) (string, error) {
+		if len(field) != 1 {
+			return "", fmt.Errorf("multiple values specified for %s", name)
+		}
+
+		return field[0], nil
+	}
+
+	kind, err := single(fields[0], "connection type")
+	if err != nil {
+		return HBARule{}, err
+	}
+
+	rule := HBARule{Type: HBAConnType(kind), Options: map[string]string{}}
+	switch rule.Type {
+	case HBALocal, HBAHost, HBAHostSSL, HBAHostNoSSL:
+	case "include", "include_if_exists", "include_dir":
+		return HBARule{}, fmt.Errorf("%s directives are not supported", kind)
+	default:
+		return HBARule{}, fmt.Errorf("invalid connection type %q", kind)
+	}
+
+	fields = fields[1:]
+	if len(fields) < 2 {
+		return HBARule{}, errors.New("end-of-line before database specification")
+	}
+
+	rule.Databases = fields[0]
+	rule.Users = fields[1]
+	fields = fields[2:]
+
+	for _, name := range append(append([]string{}, rule.Databases...), rule.Users...) {
+		if strings.HasPrefix(name, "+") || strings.HasPrefix(name, "@") {
+			return HBARule{}, fmt.Errorf("group and file references are not supported: %s", name)
+		}
+	}
+
+	for _, name := range rule.Databases {
+		if name == "samerole" || name == "samegroup" {
+			return HBARule{}, fmt.Errorf("%s is not supported", name)
+		}
+	}
+
+	if rule.Type != HBALocal {
+		if len(fields) == 0 {
+			return HBARule{}, errors.New("end-of-line before IP address specification")
+		}
+
+		address, err := single(fields[0], "IP address")
+		if err != nil {
+			return HBARule{}, err
+		}
+
+		fields = fields[1:]
+		switch {
+		case address == "all":
+		case address == "samehost", address == "samenet":
+			return HBARule{}, fmt.Errorf("%s is not supported", address)
+		case strings.Contains(address, "/"):
+			_, rule.Address, err = net.ParseCIDR(address)
+			if err != nil {
+				return HBARule{}, fmt.Errorf("invalid IP address %q: %w", address, err)
+			}
+		default:
+			ip := net.ParseIP(address)
+			if ip == nil {
+				return HBARule{}, fmt.Errorf("invalid IP address %q: host names are not supported", address)
+			}
+
+			if len(fields) == 0 {
+				return HBARule{}, errors.New("end-of-line before netmask specification")
+			}
+
+			mask, err := single(fields[0], "netmask")
+			if err != nil {
+				return HBARule{}, err
+			}
+
+			fields = fields[1:]
+			maskIP := net.ParseIP(mask)
+			if maskIP == nil {
+				return HBARule{}, fmt.Errorf("invalid IP mask %q", mask)
+			}
+
+			if ip4 := ip.To4(); ip4 != nil {
+				ip, maskIP = ip4, maskIP.To4()
+			}
+
+			if maskIP == nil || len(maskIP) != len(ip) {
+				return HBARule{}, fmt.Errorf("IP address and mask %q do not match", mask)
+			}
+
+			rule.Address = &net.IPNet{IP: ip.Mask(net.IPMask(maskIP)), Mask: net.IPMask(maskIP)}
+		}
+	}
+
+	if len(fields) == 0 {
+		return HBARule{}, errors.New("end-of-line before authentication method")
+	}
+
+	rule.Method, err = single(fields[0], "authentication method")
+	if err != nil {
+		return HBARule{}, err
+	}
+
+	for _, field := range fields[1:] {
+		for _, option := range field {
+			key, value, ok := strings.Cut(option, "=")
+			if !ok {
+				return HBARule{}, fmt.Errorf("authentication option not in name=value format: %s", option)
+			}
+
+			rule.Options[key] = strings.Trim(value, `"`)
+		}
+	}
+
+	return rule, nil
+}
+
+// hbaRule represents a prepared host based authentication rule.
+type hbaRule struct {
+	HBARule
+	databases []hbaName
+	users     []hbaName
+	strategy  AuthStrategy
+}
+
+// hbaName represents a prepared database or user name.
+type hbaName struct {
+	value   string
+	keyword bool
+	pattern *regexp.Regexp
+}
+
+// match checks whether the given name is matched. Keywords are matched
+// by the caller.
+func (name hbaName) match(value string) bool {
+	if name.pattern != nil {
+		return name.pattern.MatchString(value)
+	}
+
+	return !name.keyword && name.value == value
+}
+
+// prepareHBANames prepares the given database or user names. Unquoted names
+// matching one of the given keywords are marked as keyword.
+func prepareHBANames(names []string, keywords ...string) ([]hbaName, error) {
+	prepared := make([]hbaName, 0, len(names))
+	for _, name := range names {
+		if unquoted, ok := strings.CutPrefix(name, `"`); ok {
+			prepared = append(prepared, hbaName{value: strings.TrimSuffix(unquoted, `"`)})
+			continue
+		}
+
+		if expr, ok := strings.CutPrefix(name, "/"); ok {
+			pattern, err := regexp.Compile(expr)
+			if err != nil {
+				return nil, fmt.Errorf("invalid regular expression %q: %w", expr, err)
+			}
+
+			prepared = append(prepared, hbaName{value: name, pattern: pattern})
+			continue
+		}
+
+		prepared = append(prepared, hbaName{value: name, keyword: slices.Contains(keywords, name)})
+	}
+
+	return prepared, nil
+}
+
+// HostBasedAuth constructs a new authentication strategy evaluating the given
+// host based authentication rules in order, equivalent to pg_hba.conf. The
+// connection type, client address, database and user of the connection are
+// matched against each rule. The authentication strategy of the method of the
+// first matching rule is used to authenticate the client. Connections not
+// matching any rule are rejected. The trust and reject methods are built-in,
+// all other methods used by the given rules have to be defined inside the
+// given methods. The matched rule could be retrieved inside the strategy using
+// [MatchedHBARule].
+func HostBasedAuth(rules []HBARule, methods map[string]AuthStrategy) (AuthStrategy, error) {
+	prepared := make([]hbaRule, 0, len(rules))
+	for _, rule := range rules {
+		databases, err := prepareHBANames(rule.Databases, "all", "sameuser", "replication")
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", rule.Line, err)
+		}
+
+		users, err := prepareHBANames(rule.Users, "all")
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %w", rule.Line, err)
+		}
+
+		strategy, has := methods[rule.Method]
+		switch {
+		case has:
+		case rule.Method == HBATrust:
+			strategy = func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (context.Context, error) {
+				return ctx, writeAuthType(writer, authOK)
+			}
+		case rule.Method == HBAReject:
+			strategy = func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (context.Context, error) {
+				return ctx, writeAuthError(writer, newErrHBARejected(ctx, "pg_hba.conf rejects connection"))
+			}
+		default:
+			return nil, fmt.Errorf("line %d: unsupported authentication method %q", rule.Line, rule.Method)
+		}
+
+		prepared = append(prepared, hbaRule{
+			HBARule:   rule,
+			databases: databases,
+			users:     users,
+			strategy:  strategy,
+		})
+	}
+
+	return func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (_ context.Context, err error) {
+		for index := range prepared {
+			rule := &prepared[index]
+			if !rule.matches(ctx) {
+				continue
+			}
+
+			return rule.strategy(setHBARule(ctx, &rule.HBARule), writer, reader)
+		}
+
+		return ctx, writeAuthError(writer, newErrHBARejected(ctx, "no pg_hba.conf entry"))
+	}, nil
+}
+
+// matches checks whether the given connection is matched by the rule.
+func (rule *hbaRule) matches(ctx context.Context) bool {
+	addr := RemoteAddress(ctx)
+	_, local := addr.(*net.UnixAddr)
+	secure := TLSConnectionState(ctx) != nil
+
+	switch rule.Type {
+	case HBALocal:
+		if !local {
+			return false
+		}
+	case HBAHost, HBAHostSSL, HBAHostNoSSL:
+		if local {
+			return false
+		}
+
+		if rule.Type == HBAHostSSL && !secure || rule.Type == HBAHostNoSSL && secure {
+			return false
+		}
+
+		if rule.Address != nil && !rule.Address.Contains(remoteIP(addr)) {
+			return false
+		}
+	}
+
+	params := ClientParameters(ctx)
+	database := params[ParamDatabase]
+	username := params[ParamUsername]
+	replication := params["replication"]
+
+	return rule.matchDatabase(database, username, replication) && rule.matchUser(username)
+}
+
+// matchDatabase checks whether the given database is matched by the rule.
+func (rule *hbaRule) matchDatabase(database, username, replication string) bool {
+	// NOTE: physical replication connections are only matched by the
+	// replication keyword.
+	physical := replication != "" && replication != "database" && replication != "off" && replication != "false"
+
+	for _, name := range rule.databases {
+		switch {
+		case name.keyword && name.value == "replication":
+			if physical {
+				return true
+			}
+		case physical:
+		case name.keyword && name.value == "all":
+			return true
+		case name.keyword && name.value == "sameuser":
+			if database == username {
+				return true
+			}
+		case name.match(database):
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchUser checks whether the given user is matched by the rule.
+func (rule *hbaRule) matchUser(username string) bool {
+	for _, name := range rule.users {
+		if name.keyword && name.value == "all" || name.match(username) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// remoteIP returns the IP address of the given remote address.
+func remoteIP(addr net.Addr) net.IP {
+	switch addr := addr.(type) {
+	case *net.TCPAddr:
+		return addr.IP
+	case *net.UDPAddr:
+		return addr.IP
+	case nil:
+		return nil
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return nil
+	}
+
+	return net.ParseIP(host)
+}
+
+// newErrHBARejected constructs a new error indicating that the given
+// connection has been rejected by the host based authentication rules.
+func newErrHBARejected(ctx context.Context, reason string) error {
+	params := ClientParameters(ctx)
+
+	host := "[local]"
+	addr := RemoteAddress(ctx)
+	if _, local := addr.(*net.UnixAddr); !local && addr != nil {
+		host = addr.String()
+		if ip := remoteIP(addr); ip != nil {
+			host = ip.String()
+		}
+	}
+
+	encryption := "no encryption"
+	if TLSConnectionState(ctx) != nil {
+		encryption = "SSL encryption"
+	}
+
+	err := fmt.Errorf("%s for host %q, user %q, database %q, %s", reason, host, params[ParamUsername], params[ParamDatabase], encryption)
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.InvalidAuthorizationSpecification), pgerror.LevelFatal)
+}
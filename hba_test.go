@@ -0,0 +1,195 @@
+package wire
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseHBA(t *testing.T) {
+	t.Parallel()
+
+	config := `
+# TYPE  DATABASE        USER            ADDRESS                 METHOD
+
+local   all             all                                     peer
+host    "all",sales     admin,/^.*@example\.com$  10.0.0.0/8    scram-sha-256 # comment
+hostssl sameuser        all             192.168.1.0 255.255.255.0 \
+                                                                cert clientcert=verify-full map="my map"
+hostnossl replication   all             all                     reject
+`
+
+	rules, err := ParseHBA(strings.NewReader(config))
+	require.NoError(t, err)
+	require.Len(t, rules, 4)
+
+	assert.Equal(t, HBARule{Line: 4, Type: HBALocal, Databases: []string{"all"}, Users: []string{"all"}, Method: "peer", Options: map[string]string{}}, rules[0])
+
+	assert.Equal(t, 5, rules[1].Line)
+	assert.Equal(t, HBAHost, rules[1].Type)
+	assert.Equal(t, []string{`"all"`, "sales"}, rules[1].Databases)
+	assert.Equal(t, []string{"admin", `/^.*@example\.com$`}, rules[1].Users)
+	assert.Equal(t, "10.0.0.0/8", rules[1].Address.String())
+	assert.Equal(t, "scram-sha-256", rules[1].Method)
+
+	assert.Equal(t, 6, rules[2].Line)
+	assert.Equal(t, "192.168.1.0/24", rules[2].Address.String())
+	assert.Equal(t, map[string]string{"clientcert": "verify-full", "map": "my map"}, rules[2].Options)
+	assert.Equal(t, `hostssl sameuser all 192.168.1.0/24 cert clientcert=verify-full map=my map`, rules[2].String())
+
+	assert.Equal(t, HBAHostNoSSL, rules[3].Type)
+	assert.Nil(t, rules[3].Address)
+}
+
+func TestParseHBAInvalid(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]string{
+		"connection type":  "hostgss all all all trust",
+		"missing method":   "host all all 10.0.0.0/8",
+		"missing address":  "host all all",
+		"host name":        "host all all example.com trust",
+		"invalid cidr":     "host all all 10.0.0.0/33 trust",
+		"mask mismatch":    "host all all 10.0.0.0 ffff:: trust",
+		"unterminated":     `host "all all all trust`,
+		"include":          "include other.conf",
+		"group reference":  "host all +admins all trust",
+		"samerole":         "host samerole all all trust",
+		"invalid option":   "host all all all cert verify",
+		"continuation eof": "host all all all \\",
+	}
+
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			_, err := ParseHBA(strings.NewReader(config))
+			assert.Error(t, err)
+		})
+	}
+}
+
+func TestHostBasedAuthMatch(t *testing.T) {
+	t.Parallel()
+
+	tcp := &net.TCPAddr{IP: net.IPv4(10, 1, 2, 3), Port: 5432}
+	unix := &net.UnixAddr{Name: "/tmp/.s.PGSQL.5432", Net: "unix"}
+
+	tests := map[string]struct {
+		rule     string
+		addr     net.Addr
+		secure   bool
+		database string
+		user     string
+		expected bool
+	}{
+		"local":               {rule: "local all all trust", addr: unix, database: "db", user: "admin", expected: true},
+		"local tcp":           {rule: "local all all trust", addr: tcp, database: "db", user: "admin"},
+		"host unix":           {rule: "host all all all trust", addr: unix, database: "db", user: "admin"},
+		"host cidr":           {rule: "host all all 10.0.0.0/8 trust", addr: tcp, database: "db", user: "admin", expected: true},
+		"host cidr mismatch":  {rule: "host all all 192.168.0.0/16 trust", addr: tcp, database: "db", user: "admin"},
+		"hostssl secure":      {rule: "hostssl all all all trust", addr: tcp, secure: true, database: "db", user: "admin", expected: true},
+		"hostssl insecure":    {rule: "hostssl all all all trust", addr: tcp, database: "db", user: "admin"},
+		"hostnossl secure":    {rule: "hostnossl all all all trust", addr: tcp, secure: true, database: "db", user: "admin"},
+		"database list":       {rule: "host db1,db2 all all trust", addr: tcp, database: "db2", user: "admin", expected: true},
+		"database mismatch":   {rule: "host db1,db2 all all trust", addr: tcp, database: "db3", user: "admin"},
+		"quoted keyword":      {rule: `host "all" all all trust`, addr: tcp, database: "db", user: "admin"},
+		"sameuser":            {rule: "host sameuser all all trust", addr: tcp, database: "admin", user: "admin", expected: true},
+		"sameuser mismatch":   {rule: "host sameuser all all trust", addr: tcp, database: "db", user: "admin"},
+		"user regex":          {rule: `host all /^.*@example\.com$ all trust`, addr: tcp, database: "db", user: "john@example.com", expected: true},
+		"user regex mismatch": {rule: `host all /^.*@example\.com$ all trust`, addr: tcp, database: "db", user: "john@example.org"},
+		"replication keyword": {rule: "host replication all all trust", addr: tcp, database: "db", user: "admin"},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			rules, err := ParseHBA(strings.NewReader(test.rule))
+			require.NoError(t, err)
+
+			databases, err := prepareHBANames(rules[0].Databases, "all", "sameuser", "replication")
+			require.NoError(t, err)
+
+			users, err := prepareHBANames(rules[0].Users, "all")
+			require.NoError(t, err)
+
+			rule := &hbaRule{HBARule: rules[0], databases: databases, users: users}
+
+			ctx := setRemoteAddress(context.Background(), test.addr)
+			ctx = setClientParameters(ctx, Parameters{ParamDatabase: test.database, ParamUsername: test.user})
+			if test.secure {
+				ctx = context.WithValue(ctx, ctxTLSState, &tls.ConnectionState{})
+			}
+
+			assert.Equal(t, test.expected, rule.matches(ctx))
+		})
+	}
+}
+
+func TestHostBasedAuth(t *testing.T) {
+	t.Parallel()
+
+	config := `
+host    reject  all     all             reject
+host    all     admin   127.0.0.0/8     md5
+host    public  all     127.0.0.1/32    trust
+`
+
+	rules, err := ParseHBA(strings.NewReader(config))
+	require.NoError(t, err)
+
+	lookup := func(ctx context.Context, database, username string) (context.Context, string, error) {
+		rule := MatchedHBARule(ctx)
+		require.NotNil(t, rule)
+		assert.Equal(t, 3, rule.Line)
+		return ctx, "secret", nil
+	}
+
+	strategy, err := HostBasedAuth(rules, map[string]AuthStrategy{"md5": MD5Password(lookup)})
+	require.NoError(t, err)
+
+	server, err := NewServer(nil, Logger(slogt.New(t)), SessionAuthStrategy(strategy))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	tests := map[string]struct {
+		connstr string
+		err     string
+	}{
+		"method":   {connstr: "postgres://admin:secret@%s:%d/db"},
+		"trust":    {connstr: "postgres://user@%s:%d/public"},
+		"reject":   {connstr: "postgres://admin:secret@%s:%d/reject", err: `pg_hba.conf rejects connection for host "127.0.0.1", user "admin", database "reject", no encryption (SQLSTATE 28000)`},
+		"no entry": {connstr: "postgres://user@%s:%d/db", err: `no pg_hba.conf entry for host "127.0.0.1", user "user", database "db", no encryption (SQLSTATE 28000)`},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+			conn, err := pgx.Connect(ctx, fmt.Sprintf(test.connstr+"?sslmode=disable", address.IP, address.Port))
+			if test.err != "" {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), test.err)
+				return
+			}
+
+			require.NoError(t, err)
+			require.NoError(t, conn.Close(ctx))
+		})
+	}
+}
+
+func TestHostBasedAuthUnsupportedMethod(t *testing.T) {
+	t.Parallel()
+
+	rules, err := ParseHBA(strings.NewReader("host all all all ldap"))
+	require.NoError(t, err)
+
+	_, err = HostBasedAuth(rules, nil)
+	assert.Error(t, err)
+}
@@ -0,0 +1,152 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"sync"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+// ConnectionLimitConfig represents the connection limits enforced once a
+// client has been authenticated. Zero values disable the corresponding limit.
+type ConnectionLimitConfig struct {
+	// MaxConnections is the maximum number of concurrent connections
+	// (max_connections).
+	MaxConnections int
+	// SuperuserReservedConnections is the number of connection slots reserved
+	// for superusers (superuser_reserved_connections).
+	SuperuserReservedConnections int
+	// Roles contains the maximum number of concurrent connections per role.
+	// Superusers are not subject to these limits.
+	Roles map[string]int
+	// Databases contains the maximum number of concurrent connections per
+	// database. Superusers are not subject to these limits.
+	Databases map[string]int
+}
+
+// ConnectionStats represents the number of authenticated connections served
+// by the server.
+type ConnectionStats struct {
+	Total     int
+	Roles     map[string]int
+	Databases map[string]int
+}
+
+// connectionTracker tracks the authenticated connections and enforces the
+// configured connection limits.
+type connectionTracker struct {
+	mu        sync.Mutex
+	limits    ConnectionLimitConfig
+	total     int
+	roles     map[string]int
+	databases map[string]int
+}
+
+// newConnectionTracker constructs a new connection tracker without any limits.
+func newConnectionTracker() *connectionTracker {
+	return &connectionTracker{
+		roles:     make(map[string]int),
+		databases: make(map[string]int),
+	}
+}
+
+// acquire claims a connection slot for the client connected to the given
+// context. A FATAL too_many_connections error is returned whenever one of the
+// connection limits has been reached. The returned function has to be called
+// once the connection has been closed.
+func (tracker *connectionTracker) acquire(ctx context.Context) (release func(), err error) {
+	var role string
+	var superuser bool
+	if authenticated := AuthenticatedRole(ctx); authenticated != nil {
+		role = authenticated.Name
+		superuser = authenticated.Superuser
+	}
+
+	database := ClientParameters(ctx)[ParamDatabase]
+
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	err = tracker.check(role, database, superuser)
+	if err != nil {
+		return nil, pgerror.WithSeverity(pgerror.WithCode(err, codes.TooManyConnections), pgerror.LevelFatal)
+	}
+
+	tracker.total++
+	tracker.roles[role]++
+	tracker.databases[database]++
+
+	var once sync.Once
+	release = func() {
+		once.Do(func() {
+			tracker.mu.Lock()
+			defer tracker.mu.Unlock()
+
+			tracker.total--
+			decrementCount(tracker.roles, role)
+			decrementCount(tracker.databases, database)
+		})
+	}
+
+	return release, nil
+}
+
+// check checks whether a new connection for the given role and database is
+// allowed, following the PostgreSQL error messages.
+func (tracker *connectionTracker) check(role, database string, superuser bool) error {
+	limits := tracker.limits
+	if limits.MaxConnections > 0 {
+		if tracker.total >= limits.MaxConnections {
+			return errors.New("sorry, too many clients already")
+		}
+
+		if !superuser && limits.SuperuserReservedConnections > 0 && tracker.total >= limits.MaxConnections-limits.SuperuserReservedConnections {
+			return errors.New("remaining connection slots are reserved for roles with the SUPERUSER attribute")
+		}
+	}
+
+	if superuser {
+		return nil
+	}
+
+	if limit, has := limits.Roles[role]; has && tracker.roles[role] >= limit {
+		return fmt.Errorf("too many connections for role %q", role)
+	}
+
+	if limit, has := limits.Databases[database]; has && tracker.databases[database] >= limit {
+		return fmt.Errorf("too many connections for database %q", database)
+	}
+
+	return nil
+}
+
+// stats returns a snapshot of the current connection counts.
+func (tracker *connectionTracker) stats() ConnectionStats {
+	tracker.mu.Lock()
+	defer tracker.mu.Unlock()
+
+	return ConnectionStats{
+		Total:     tracker.total,
+		Roles:     maps.Clone(tracker.roles),
+		Databases: maps.Clone(tracker.databases),
+	}
+}
+
+// decrementCount decrements the counter of the given key and removes the key
+// once the counter reaches zero.
+func decrementCount(counters map[string]int, key string) {
+	counters[key]--
+	if counters[key] <= 0 {
+		delete(counters, key)
+	}
+}
+
+// ConnectionStats returns the number of authenticated connections currently
+// served by the server, in total and per role and database.
+func (srv *Server) ConnectionStats() ConnectionStats {
+	return srv.connections.stats()
+}
@@ -0,0 +1,131 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestConnectionTracker(t *testing.T) {
+	t.Parallel()
+
+	tracker := newConnectionTracker()
+	tracker.limits = ConnectionLimitConfig{
+		MaxConnections:               4,
+		SuperuserReservedConnections: 1,
+		Roles:                        map[string]int{"alice": 1},
+		Databases:                    map[string]int{"reports": 1},
+	}
+
+	acquire := func(role, database string, superuser bool) (func(), error) {
+		ctx := setClientParameters(context.Background(), Parameters{ParamDatabase: database})
+		ctx = WithRole(ctx, &Role{Name: role, Superuser: superuser})
+		return tracker.acquire(ctx)
+	}
+
+	release, err := acquire("alice", "app", false)
+	require.NoError(t, err)
+
+	_, err = acquire("alice", "app", false)
+	require.ErrorContains(t, err, `too many connections for role "alice"`)
+
+	_, err = acquire("bob", "reports", false)
+	require.NoError(t, err)
+
+	_, err = acquire("carol", "reports", false)
+	require.ErrorContains(t, err, `too many connections for database "reports"`)
+
+	// NOTE: superusers are not subject to role and database limits
+	_, err = acquire("alice", "reports", true)
+	require.NoError(t, err)
+
+	_, err = acquire("carol", "app", false)
+	require.ErrorContains(t, err, "remaining connection slots are reserved")
+
+	_, err = acquire("admin", "app", true)
+	require.NoError(t, err)
+
+	_, err = acquire("admin", "app", true)
+	require.ErrorContains(t, err, "sorry, too many clients already")
+
+	stats := tracker.stats()
+	assert.Equal(t, 4, stats.Total)
+	assert.Equal(t, map[string]int{"alice": 2, "bob": 1, "admin": 1}, stats.Roles)
+	assert.Equal(t, map[string]int{"app": 2, "reports": 2}, stats.Databases)
+
+	release()
+	release()
+
+	stats = tracker.stats()
+	assert.Equal(t, 3, stats.Total)
+	assert.Equal(t, map[string]int{"alice": 1, "bob": 1, "admin": 1}, stats.Roles)
+}
+
+func TestConnectionLimits(t *testing.T) {
+	t.Parallel()
+
+	roles := map[string]*Role{
+		"admin": {Name: "admin", Superuser: true},
+		"bob":   {Name: "bob"},
+	}
+
+	lookup := func(ctx context.Context, name string) (*Role, error) {
+		return roles[name], nil
+	}
+
+	server, err := NewServer(nil,
+		Logger(slogt.New(t)),
+		RoleLookup(lookup),
+		ConnectionLimits(ConnectionLimitConfig{MaxConnections: 2, SuperuserReservedConnections: 1}),
+	)
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connect := func(user string) (*pgx.Conn, error) {
+		return pgx.Connect(context.Background(), fmt.Sprintf("postgres://%s@%s:%d/app?sslmode=disable", user, address.IP, address.Port))
+	}
+
+	bob, err := connect("bob")
+	require.NoError(t, err)
+
+	_, err = connect("bob")
+	require.Error(t, err)
+
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, string(codes.TooManyConnections), pgErr.Code)
+	assert.Equal(t, "FATAL", pgErr.Severity)
+
+	admin, err := connect("admin")
+	require.NoError(t, err)
+	defer admin.Close(context.Background()) //nolint:errcheck
+
+	_, err = connect("admin")
+	require.ErrorContains(t, err, "sorry, too many clients already")
+
+	stats := server.ConnectionStats()
+	assert.Equal(t, 2, stats.Total)
+	assert.Equal(t, map[string]int{"admin": 1, "bob": 1}, stats.Roles)
+	assert.Equal(t, map[string]int{"app": 2}, stats.Databases)
+
+	require.NoError(t, bob.Close(context.Background()))
+	require.Eventually(t, func() bool {
+		return server.ConnectionStats().Total == 1
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestConnectionLimitsOption(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewServer(nil, ConnectionLimits(ConnectionLimitConfig{MaxConnections: 1, SuperuserReservedConnections: 1}))
+	require.Error(t, err)
+}
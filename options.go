@@ -3,9 +3,13 @@ package wire
 import (
 	"context"
 	"crypto/tls"
+	"errors"
+	"fmt"
 	"log/slog"
+	"net"
 	"regexp"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jackc/pgx/v5/pgtype"
@@ -170,8 +174,11 @@ type SyncFn func(ctx context.Context, failed bool) error
 
 // CancelRequestFn function called when a cancel request is received.
 // The function receives the process ID and secret key from the cancel request.
+// The secret key is 4 bytes long for clients using a protocol version prior to
+// 3.2 and up to 256 bytes long otherwise. Secret keys should be compared in
+// constant time (ex: [crypto/subtle.ConstantTimeCompare]).
 // It should return an error if the cancel request cannot be processed.
-type CancelRequestFn func(ctx context.Context, processID int32, secretKey int32) error
+type CancelRequestFn func(ctx context.Context, processID int32, secretKey []byte) error
 
 // OptionFn options pattern used to define and set options for the given
 // PostgreSQL server.
@@ -272,16 +279,50 @@ func ErrorSanitizer(fn func(error) error) OptionFn {
 	}
 }
 
-// MessageBufferSize sets the message buffer size which is allocated once a new
-// connection gets constructed. If a negative value or zero value is provided is
-// the default message buffer size used.
+// MessageBufferSize sets the maximum message size accepted from clients. If a
+// negative value or zero value is provided is the default maximum message size
+// used.
+//
+// Deprecated: the message buffer is no longer allocated upfront, use
+// [MaxMessageSize] and [ReadBufferSize] instead.
 func MessageBufferSize(size int) OptionFn {
+	return MaxMessageSize(size)
+}
+
+// MaxMessageSize sets the maximum message size accepted from clients. Messages
+// exceeding the maximum message size are discarded and an error is returned to
+// the client. Memory for messages is allocated on demand. If a negative value
+// or zero value is provided is the default maximum message size (16 MiB) used.
+func MaxMessageSize(size int) OptionFn {
 	return func(srv *Server) error {
 		srv.BufferedMsgSize = size
 		return nil
 	}
 }
 
+// ReadBufferSize sets the size of the read buffer which is allocated for each
+// connection to buffer incoming data. Messages exceeding the read buffer size
+// are read directly from the connection. If a negative value or zero value is
+// provided is the default read buffer size (8 KiB) used.
+func ReadBufferSize(size int) OptionFn {
+	return func(srv *Server) error {
+		srv.ReadBufferSize = size
+		return nil
+	}
+}
+
+// FlushThreshold sets the amount of bytes which are buffered before the
+// messages written to a session are flushed to the client. The default flush
+// threshold is used whenever a zero value is provided, a negative value
+// disables output buffering causing every message to be written directly to
+// the client.
+func FlushThreshold(size int) OptionFn {
+	return func(srv *Server) error {
+		srv.FlushThreshold = size
+		return nil
+	}
+}
+
 // ClientAuth sets the client authentication type which is used to authenticate
 // the client connection. The default value is [tls.NoClientCert] which means
 // that no client authentication is performed.
@@ -301,6 +342,61 @@ func TLSConfig(config *tls.Config) OptionFn {
 	}
 }
 
+// ReloadCertificates loads the TLS certificates from the given files and
+// watches the files for changes. Certificates are validated before being
+// swapped, only new TLS handshakes use the reloaded certificates leaving
+// established connections untouched. Rotation events and invalid certificates
+// are logged using the server logger. The configured certificate files take
+// precedence over the certificates of the TLS config, all other TLS config
+// options are preserved.
+func ReloadCertificates(config CertificateReloadConfig) OptionFn {
+	return func(srv *Server) error {
+		reloader, err := newCertificateReloader(config)
+		if err != nil {
+			return fmt.Errorf("unable to load TLS certificates: %w", err)
+		}
+
+		srv.tlsReloader = reloader
+		return nil
+	}
+}
+
+// ProxyProtocol enables the PROXY protocol (v1 and v2) for connections
+// established from the given trusted networks (CIDR notation, ex:
+// 10.0.0.0/8). Connections from trusted networks are required to start with a
+// PROXY protocol header, the original client address is reported through
+// [RemoteAddress] and the header is available through [ProxyProtocolHeader].
+// Connections from other networks are served as regular connections.
+func ProxyProtocol(trusted ...string) OptionFn {
+	return func(srv *Server) error {
+		for _, cidr := range trusted {
+			_, network, err := net.ParseCIDR(cidr)
+			if err != nil {
+				return fmt.Errorf("invalid trusted proxy network %q: %w", cidr, err)
+			}
+
+			srv.ProxyTrustedNetworks = append(srv.ProxyTrustedNetworks, network)
+		}
+
+		return nil
+	}
+}
+
+// ConnectionLimits sets the connection limits enforced once a client has been
+// authenticated. Clients exceeding any of the limits receive a FATAL
+// too_many_connections (53300) error. The current connection counts could be
+// retrieved using [Server.ConnectionStats].
+func ConnectionLimits(config ConnectionLimitConfig) OptionFn {
+	return func(srv *Server) error {
+		if config.MaxConnections > 0 && config.SuperuserReservedConnections >= config.MaxConnections {
+			return errors.New("superuser reserved connections must be less than max connections")
+		}
+
+		srv.connections.limits = config
+		return nil
+	}
+}
+
 // SessionAuthStrategy sets the given authentication strategy within the given
 // server. The authentication strategy is called when a handshake is initiated.
 func SessionAuthStrategy(fn AuthStrategy) OptionFn {
@@ -330,6 +426,24 @@ func CancelRequest(fn CancelRequestFn) OptionFn {
 	}
 }
 
+// CancelRouting enables the built-in routing of cancel requests. Backend key
+// data containing a unique process ID and a cryptographically secure random
+// secret key is send to each client. Incoming cancel requests are matched
+// against the served sessions, the context passed to the statement executed
+// by the matching session is canceled and a query_canceled (57014) error is
+// reported to the client once the statement returns the context error.
+//
+// NOTE: cancel requests are routed to sessions using the process ID returned
+// by the BackendKeyDataFunc. A custom BackendKeyDataFunc configured after this
+// option is used as long as it returns unique process ID's.
+func CancelRouting() OptionFn {
+	return func(srv *Server) error {
+		srv.BackendKeyData = srv.generateBackendKeyData
+		srv.CancelRequest = srv.routeCancelRequest
+		return nil
+	}
+}
+
 // GlobalParameters sets the server parameters which are send back to the
 // front-end (client) once a handshake has been established.
 func GlobalParameters(params Parameters) OptionFn {
@@ -339,6 +453,31 @@ func GlobalParameters(params Parameters) OptionFn {
 	}
 }
 
+// VirtualHost configures a virtual host served to clients sending the given
+// server name (SNI) during the TLS handshake. A leading wildcard label (ex:
+// *.example.com) matches any server name inside the given domain. Virtual hosts
+// require TLS to be configured since the server name is only sent during the
+// TLS handshake, the server TLS config is used for unknown server names.
+func VirtualHost(serverName string, config VirtualHostConfig) OptionFn {
+	return func(srv *Server) error {
+		serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+		if serverName == "" {
+			return errors.New("virtual host server name cannot be empty")
+		}
+
+		if srv.VirtualHosts == nil {
+			srv.VirtualHosts = make(map[string]*VirtualHostConfig)
+		}
+
+		if _, has := srv.VirtualHosts[serverName]; has {
+			return fmt.Errorf("virtual host %q has already been configured", serverName)
+		}
+
+		srv.VirtualHosts[serverName] = &config
+		return nil
+	}
+}
+
 // Logger sets the given [slog.Logger] as the logger for the given server.
 func Logger(logger *slog.Logger) OptionFn {
 	return func(srv *Server) error {
@@ -367,6 +506,94 @@ func WithShutdownTimeout(timeout time.Duration) OptionFn {
 	}
 }
 
+// WithShutdownMode sets the way active sessions are treated when Shutdown is
+// called. Smart shutdowns are used by default.
+func WithShutdownMode(mode ShutdownMode) OptionFn {
+	return func(srv *Server) error {
+		if mode < ShutdownSmart || mode > ShutdownImmediate {
+			return fmt.Errorf("unknown shutdown mode: %d", mode)
+		}
+
+		srv.ShutdownMode = mode
+		return nil
+	}
+}
+
+// RoleLookup sets the role lookup used to resolve database roles. The role of
+// the authenticated user is resolved using the given lookup once the client
+// has been authenticated, unless a role has been attached by the
+// authentication strategy using [WithRole]. Roles are also resolved when
+// changing roles using [SetRole] and [SetSessionAuthorization].
+func RoleLookup(fn RoleLookupFn) OptionFn {
+	return func(srv *Server) error {
+		srv.RoleLookup = fn
+		return nil
+	}
+}
+
+// AuthenticationTimeout sets the maximum duration of the startup phase, from
+// accepting the connection until the client has been authenticated. Clients
+// which have not been authenticated within the given duration are sent a
+// FATAL error and disconnected, preventing clients from holding on to
+// connections indefinitely during authentication. A timeout of 0 disables the
+// timeout.
+func AuthenticationTimeout(timeout time.Duration) OptionFn {
+	return func(srv *Server) error {
+		srv.AuthenticationTimeout = timeout
+		return nil
+	}
+}
+
+// StartupTimeout sets the maximum duration from accepting the connection until
+// the startup packet has been received, including the SSL negotiation.
+// Clients which have not sent a complete startup packet within the given
+// duration are sent a FATAL protocol_violation error and disconnected,
+// preventing clients from holding on to connections by sending the startup
+// packet slowly. A timeout of 0 disables the timeout.
+func StartupTimeout(timeout time.Duration) OptionFn {
+	return func(srv *Server) error {
+		srv.StartupTimeout = timeout
+		return nil
+	}
+}
+
+// IdleSessionTimeout sets the maximum duration a session is allowed to wait
+// for a new query while not inside a transaction (idle_session_timeout).
+// Sessions exceeding the given duration are sent a FATAL idle_session_timeout
+// error and disconnected. A timeout of 0 disables the timeout.
+func IdleSessionTimeout(timeout time.Duration) OptionFn {
+	return func(srv *Server) error {
+		srv.IdleSessionTimeout = timeout
+		return nil
+	}
+}
+
+// StatementTimeout sets the default maximum duration of a statement
+// (statement_timeout). The context passed to statements exceeding the given
+// duration is canceled and a query_canceled error is reported to the client.
+// Sessions are able to override the timeout using [SetStatementTimeout]. A
+// timeout of 0 disables the timeout.
+func StatementTimeout(timeout time.Duration) OptionFn {
+	return func(srv *Server) error {
+		srv.StatementTimeout = timeout
+		return nil
+	}
+}
+
+// IdleInTransactionSessionTimeout sets the default maximum duration a
+// session is allowed to wait for a new query while inside a transaction
+// (idle_in_transaction_session_timeout). The transaction status is obtained
+// through the configured [TxStatusFn]. Sessions exceeding the given duration
+// are sent a FATAL idle_in_transaction_session_timeout error and
+// disconnected. Sessions are able to override the timeout using
+// [SetIdleInTransactionSessionTimeout]. A timeout of 0 disables the timeout.
+func IdleInTransactionSessionTimeout(timeout time.Duration) OptionFn {
+	return func(srv *Server) error {
+		srv.IdleInTransactionSessionTimeout = timeout
+		return nil
+	}
+}
+
 // ExtendTypes provides the ability to extend the underlying connection types.
 // Types registered inside the given [github.com/jackc/pgx/v5/pgtype.Map] are
 // registered to all incoming connections.
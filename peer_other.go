@@ -0,0 +1,13 @@
+//go:build !linux
+
+package wire
+
+import (
+	"errors"
+	"net"
+)
+
+// peerCredentials is not supported on the current platform.
+func peerCredentials(conn *net.UnixConn) (*PeerCred, error) {
+	return nil, errors.New("peer credentials are not supported on this platform")
+}
@@ -11,9 +11,24 @@ import (
 	"github.com/jeroenrinzema/psql-wire/pkg/types"
 )
 
-// DefaultBufferSize represents the default buffer size whenever the buffer size
-// is not set or a negative value is presented.
-const DefaultBufferSize = 1 << 24 // 16777216 bytes
+// DefaultMaxMessageSize represents the default maximum message size whenever
+// the maximum message size is not set or a negative value is presented.
+const DefaultMaxMessageSize = 1 << 24 // 16777216 bytes
+
+// DefaultBufferSize represents the default maximum message size whenever the
+// maximum message size is not set or a negative value is presented.
+//
+// Deprecated: the maximum message size is no longer allocated as read buffer,
+// use DefaultMaxMessageSize instead.
+const DefaultBufferSize = DefaultMaxMessageSize
+
+// DefaultReadBufferSize represents the default read buffer size whenever the
+// read buffer size is not set or a negative value is presented.
+const DefaultReadBufferSize = 1 << 13 // 8192 bytes
+
+// minMessageBufferSize represents the minimal size of an allocated message
+// buffer. Small messages share the spare capacity of the message buffer.
+const minMessageBufferSize = 1 << 12 // 4096 bytes
 
 // BufferedReader extended io.Reader with some convenience methods.
 type BufferedReader interface {
@@ -29,22 +44,67 @@ type Reader struct {
 	Msg            []byte
 	MaxMessageSize int
 	header         [4]byte
+	bufferSize     int
+}
+
+// NewReader constructs a new Postgres wire buffer for the given io.Reader. The
+// given size is used as the maximum message size, the default read buffer size
+// is used to buffer incoming data.
+func NewReader(logger *slog.Logger, reader io.Reader, maxMessageSize int) *Reader {
+	return NewReaderSize(logger, reader, DefaultReadBufferSize, maxMessageSize)
 }
 
-// NewReader constructs a new Postgres wire buffer for the given io.Reader
-func NewReader(logger *slog.Logger, reader io.Reader, bufferSize int) *Reader {
+// NewReaderSize constructs a new Postgres wire buffer for the given io.Reader
+// using the given read buffer size and maximum message size. The read buffer
+// is allocated once for the lifetime of the reader. Messages are read into a
+// message buffer which grows on demand up to the maximum message size, large
+// message buffers are released by calling Release once the message has been
+// handled. The
+// defaults are used whenever a zero or negative size is given.
+func NewReaderSize(logger *slog.Logger, reader io.Reader, bufferSize, maxMessageSize int) *Reader {
 	if reader == nil {
 		return nil
 	}
 
 	if bufferSize <= 0 {
-		bufferSize = DefaultBufferSize
+		bufferSize = DefaultReadBufferSize
+	}
+
+	if maxMessageSize <= 0 {
+		maxMessageSize = DefaultMaxMessageSize
 	}
 
 	return &Reader{
 		logger:         logger,
 		Buffer:         bufio.NewReaderSize(reader, bufferSize),
-		MaxMessageSize: bufferSize,
+		MaxMessageSize: maxMessageSize,
+		bufferSize:     bufferSize,
+	}
+}
+
+// Buffered returns the number of bytes which could be read from the buffered
+// reader without reading from the underlying reader. Zero is returned whenever
+// the buffered reader does not expose the number of buffered bytes.
+func (reader *Reader) Buffered() int {
+	buffered, ok := reader.Buffer.(interface{ Buffered() int })
+	if !ok {
+		return 0
+	}
+
+	return buffered.Buffered()
+}
+
+// Release releases the message buffer whenever its capacity exceeds the read
+// buffer size. Release should be called once a message has been handled to
+// avoid retaining large message buffers while the connection is idle.
+func (reader *Reader) Release() {
+	bufferSize := reader.bufferSize
+	if bufferSize <= 0 {
+		bufferSize = DefaultReadBufferSize
+	}
+
+	if cap(reader.Msg) > max(bufferSize, minMessageBufferSize) {
+		reader.Msg = nil
 	}
 }
 
@@ -62,8 +122,8 @@ func (reader *Reader) reset(size int) {
 	}
 
 	allocSize := size
-	if allocSize < 4096 {
-		allocSize = 4096
+	if allocSize < minMessageBufferSize {
+		allocSize = minMessageBufferSize
 	}
 	reader.Msg = make([]byte, size, allocSize)
 }
@@ -94,27 +154,11 @@ func (reader *Reader) ReadTypedMsg() (types.ClientMessage, int, error) {
 	return typed, n, nil
 }
 
-// Slurp reads the remaining
+// Slurp reads and discards the given amount of bytes from the reader. The
+// discarded bytes are not read into the message buffer.
 func (reader *Reader) Slurp(size int) error {
-	remaining := size
-	for remaining > 0 {
-		reading := remaining
-
-		if reading > reader.MaxMessageSize {
-			reading = reader.MaxMessageSize
-		}
-
-		reader.reset(reading)
-
-		n, err := io.ReadFull(reader.Buffer, reader.Msg)
-		if err != nil {
-			return err
-		}
-
-		remaining -= n
-	}
-
-	return nil
+	_, err := io.CopyN(io.Discard, reader.Buffer, int64(size))
+	return err
 }
 
 // ReadMsgSize reads the length of the next message from the provided reader.
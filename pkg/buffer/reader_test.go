@@ -274,3 +274,81 @@ func TestMsgReset(t *testing.T) {
 		}
 	})
 }
+
+func TestNewReaderSize(t *testing.T) {
+	buffer := bytes.NewBuffer([]byte{})
+
+	reader := NewReader(slogt.New(t), buffer, 0)
+	if size := reader.Buffer.(*bufio.Reader).Size(); size != DefaultReadBufferSize {
+		t.Errorf("unexpected read buffer size %d, expected %d", size, DefaultReadBufferSize)
+	}
+
+	if reader.MaxMessageSize != DefaultMaxMessageSize {
+		t.Errorf("unexpected max message size %d, expected %d", reader.MaxMessageSize, DefaultMaxMessageSize)
+	}
+
+	reader = NewReaderSize(slogt.New(t), buffer, 64, 1024)
+	if size := reader.Buffer.(*bufio.Reader).Size(); size != 64 {
+		t.Errorf("unexpected read buffer size %d, expected 64", size)
+	}
+
+	if reader.MaxMessageSize != 1024 {
+		t.Errorf("unexpected max message size %d, expected 1024", reader.MaxMessageSize)
+	}
+}
+
+func TestReadLargeMessage(t *testing.T) {
+	write := func(buffer *bytes.Buffer, size int) {
+		buffer.WriteByte(byte(types.ClientSimpleQuery))
+		buffer.Write(binary.BigEndian.AppendUint32(nil, uint32(size+4)))
+		buffer.Write(bytes.Repeat([]byte{'a'}, size))
+	}
+
+	buffer := bytes.NewBuffer([]byte{})
+	write(buffer, 1<<16)
+	write(buffer, 8)
+	write(buffer, 1<<17)
+
+	reader := NewReaderSize(slogt.New(t), buffer, 64, 1<<16)
+
+	_, ln, err := reader.ReadTypedMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if ln != 4+1<<16 || len(reader.Msg) != 1<<16 {
+		t.Fatalf("unexpected message length %d, expected %d", len(reader.Msg), 1<<16)
+	}
+
+	// NOTE: the large message buffer is released once the message has been handled
+	reader.Release()
+	if cap(reader.Msg) != 0 {
+		t.Fatalf("unexpected message buffer capacity %d, expected the buffer to be released", cap(reader.Msg))
+	}
+
+	_, _, err = reader.ReadTypedMsg()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE: small message buffers are retained to be reused
+	reader.Release()
+	if cap(reader.Msg) == 0 || cap(reader.Msg) > minMessageBufferSize {
+		t.Fatalf("unexpected message buffer capacity %d, expected the buffer to be retained", cap(reader.Msg))
+	}
+
+	_, _, err = reader.ReadTypedMsg()
+	unwrapped, has := UnwrapMessageSizeExceeded(err)
+	if !has {
+		t.Fatalf("unexpected error %v, expected message size exceeded", err)
+	}
+
+	err = reader.Slurp(unwrapped.Size)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if buffer.Len() != 0 {
+		t.Fatalf("unexpected remaining bytes %d, expected the message to be discarded", buffer.Len())
+	}
+}
@@ -1,25 +1,38 @@
 package buffer
 
 import (
-	"bytes"
+	"context"
 	"encoding/binary"
 	"io"
 	"log/slog"
+	"math"
 
 	"github.com/jeroenrinzema/psql-wire/pkg/types"
 )
 
+// DefaultFlushThreshold represents the default amount of buffered bytes after
+// which the output buffer is written to the underlying writer.
+const DefaultFlushThreshold = 1 << 13 // 8192 bytes
+
+// maxFrameSize represents the maximum capacity of a data frame which is reused
+// between messages. Larger frames are released to avoid retaining large
+// allocations for the lifetime of the writer.
+const maxFrameSize = 1 << 20 // 1048576 bytes
+
 // Writer provides a convenient way to write pgwire protocol messages
 type Writer struct {
 	io.Writer
 	logger         *slog.Logger
-	frame          bytes.Buffer
+	frame          []byte   // data frame containing the active message, the frame is reused between messages
 	putbuf         [64]byte // buffer used to construct messages which could be written to the writer frame buffer
+	out            []byte   // output buffer containing the messages which have not been flushed yet
+	threshold      int      // output buffering is disabled whenever the threshold is zero
 	err            error
 	ErrorSanitizer func(error) error
 }
 
-// NewWriter constructs a new Postgres buffered message writer for the given io.Writer
+// NewWriter constructs a new Postgres buffered message writer for the given
+// io.Writer. Messages are written to the given writer once they are ended.
 func NewWriter(logger *slog.Logger, writer io.Writer) *Writer {
 	return &Writer{
 		logger: logger,
@@ -27,13 +40,39 @@ func NewWriter(logger *slog.Logger, writer io.Writer) *Writer {
 	}
 }
 
+// NewBufferedWriter constructs a new Postgres message writer which accumulates
+// ended messages inside an output buffer. The output buffer is written to the
+// given io.Writer once Flush is called or once the buffered messages exceed the
+// given threshold. The default flush threshold is used whenever a zero or
+// negative threshold is given.
+func NewBufferedWriter(logger *slog.Logger, writer io.Writer, threshold int) *Writer {
+	w := NewWriter(logger, writer)
+	w.SetFlushThreshold(threshold)
+	return w
+}
+
+// SetFlushThreshold enables output buffering for the writer. Messages are
+// written to the underlying writer once Flush is called or once the buffered
+// messages exceed the given threshold. The default flush threshold is used
+// whenever a zero or negative threshold is given.
+//
+// NOTE: callers are responsible for flushing the writer before awaiting a
+// response from the client.
+func (writer *Writer) SetFlushThreshold(threshold int) {
+	if threshold <= 0 {
+		threshold = DefaultFlushThreshold
+	}
+
+	writer.threshold = threshold
+}
+
 // Start resets the buffer writer and starts a new message with the given
 // message type. The message type (byte) and reserved message length bytes (int32)
 // are written to the underlaying bytes buffer.
 func (writer *Writer) Start(t types.ServerMessage) {
 	writer.Reset()
 	writer.putbuf[0] = byte(t)
-	writer.frame.Write(writer.putbuf[:5]) // message type + message length
+	writer.frame = append(writer.frame, writer.putbuf[:5]...) // message type + message length
 }
 
 // AddByte writes the given byte to the writer frame. Bytes written to the
@@ -44,7 +83,7 @@ func (writer *Writer) AddByte(b byte) {
 		return
 	}
 
-	writer.err = writer.frame.WriteByte(b)
+	writer.frame = append(writer.frame, b)
 }
 
 // AddInt16 writes the given unsigned int16 to the writer frame. Bytes written to the
@@ -55,10 +94,8 @@ func (writer *Writer) AddInt16(i int16) (size int) {
 		return size
 	}
 
-	x := make([]byte, 2)
-	binary.BigEndian.PutUint16(x, uint16(i))
-	size, writer.err = writer.frame.Write(x)
-	return size
+	writer.frame = binary.BigEndian.AppendUint16(writer.frame, uint16(i))
+	return 2
 }
 
 // AddInt32 writes the given unsigned int32 to the writer frame. Bytes written to the
@@ -69,10 +106,8 @@ func (writer *Writer) AddInt32(i int32) (size int) {
 		return size
 	}
 
-	x := make([]byte, 4)
-	binary.BigEndian.PutUint32(x, uint32(i))
-	size, writer.err = writer.frame.Write(x)
-	return size
+	writer.frame = binary.BigEndian.AppendUint32(writer.frame, uint32(i))
+	return 4
 }
 
 // AddBytes writes the given bytes to the writer frame. Bytes written to the
@@ -83,8 +118,8 @@ func (writer *Writer) AddBytes(b []byte) (size int) {
 		return size
 	}
 
-	size, writer.err = writer.frame.Write(b)
-	return size
+	writer.frame = append(writer.frame, b...)
+	return len(b)
 }
 
 // AddString writes the given string to the writer frame. Bytes written to the
@@ -95,8 +130,8 @@ func (writer *Writer) AddString(s string) (size int) {
 		return size
 	}
 
-	size, writer.err = writer.frame.WriteString(s)
-	return size
+	writer.frame = append(writer.frame, s...)
+	return len(s)
 }
 
 // AddNullTerminate writes a null terminate symbol to the end of the given data frame
@@ -105,7 +140,45 @@ func (writer *Writer) AddNullTerminate() {
 		return
 	}
 
-	writer.err = writer.frame.WriteByte(0)
+	writer.frame = append(writer.frame, 0)
+}
+
+// Encoder represents a append-style value encoder such as [pgtype.Map]. The
+// given value is encoded using the given type oid and format code and appended
+// to the given buffer. A nil buffer is returned for NULL values.
+//
+// [pgtype.Map]: https://pkg.go.dev/github.com/jackc/pgx/v5/pgtype#Map.Encode
+type Encoder interface {
+	Encode(oid uint32, format int16, value any, buf []byte) ([]byte, error)
+}
+
+// AddValue encodes the given value directly into the writer frame using the
+// given encoder. The encoded value is prefixed with its length (int32), NULL
+// values are written as a length of -1 without any value bytes. The frame is
+// left untouched whenever the value could not be encoded.
+func (writer *Writer) AddValue(encoder Encoder, oid uint32, format int16, value any) error {
+	if writer.err != nil {
+		return writer.err
+	}
+
+	offset := len(writer.frame)
+	frame := append(writer.frame, 0, 0, 0, 0) // value length
+
+	encoded, err := encoder.Encode(oid, format, value, frame)
+	if err != nil {
+		writer.frame = frame[:offset]
+		return err
+	}
+
+	// NOTE: the encoder returns a nil buffer for NULL values
+	if encoded == nil {
+		writer.frame = binary.BigEndian.AppendUint32(frame[:offset], math.MaxUint32)
+		return nil
+	}
+
+	binary.BigEndian.PutUint32(encoded[offset:], uint32(len(encoded)-offset-4))
+	writer.frame = encoded
+	return nil
 }
 
 func (writer *Writer) Error() error {
@@ -114,12 +187,17 @@ func (writer *Writer) Error() error {
 
 // Bytes returns the written bytes to the active data frame
 func (writer *Writer) Bytes() []byte {
-	return writer.frame.Bytes()
+	return writer.frame
 }
 
-// Reset resets the data frame to be empty
+// Reset resets the data frame to be empty. The data frame is reused for the
+// upcoming messages unless it exceeds the max retained frame size.
 func (writer *Writer) Reset() {
-	writer.frame.Reset()
+	writer.frame = writer.frame[:0]
+	if cap(writer.frame) > maxFrameSize {
+		writer.frame = nil
+	}
+
 	writer.err = nil
 }
 
@@ -131,12 +209,71 @@ func (writer *Writer) End() error {
 		return writer.Error()
 	}
 
-	bytes := writer.frame.Bytes()
-	length := uint32(writer.frame.Len() - 1) // total message length minus the message type byte
+	bytes := writer.frame
+	length := uint32(len(writer.frame) - 1) // total message length minus the message type byte
 	binary.BigEndian.PutUint32(bytes[1:5], length)
 	_, err := writer.Write(bytes)
 
-	writer.logger.Debug("-> writing message", slog.String("type", types.ServerMessage(bytes[0]).String()))
+	// NOTE: the log level is checked upfront to avoid allocating the log
+	// attributes for every written message.
+	if writer.logger.Enabled(context.Background(), slog.LevelDebug) {
+		writer.logger.Debug("-> writing message", slog.String("type", types.ServerMessage(bytes[0]).String()))
+	}
+
+	return err
+}
+
+// Write writes the given bytes to the output buffer whenever output buffering
+// is enabled. The output buffer is flushed once the flush threshold has been
+// reached. Bytes are written directly to the underlying writer otherwise.
+func (writer *Writer) Write(b []byte) (int, error) {
+	if writer.threshold == 0 {
+		return writer.Writer.Write(b)
+	}
+
+	// NOTE: messages exceeding the flush threshold are written directly to
+	// the underlying writer to avoid copying and retaining large buffers.
+	if len(b) >= writer.threshold {
+		err := writer.Flush()
+		if err != nil {
+			return 0, err
+		}
+
+		return writer.Writer.Write(b)
+	}
+
+	writer.out = append(writer.out, b...)
+	if len(writer.out) >= writer.threshold {
+		err := writer.Flush()
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return len(b), nil
+}
+
+// Buffered returns the number of bytes which have been written to the output
+// buffer but have not been flushed yet.
+func (writer *Writer) Buffered() int {
+	return len(writer.out)
+}
+
+// Flush writes all buffered messages to the underlying writer. Flush is a
+// no-op whenever no messages are pending.
+func (writer *Writer) Flush() error {
+	if len(writer.out) == 0 {
+		return nil
+	}
+
+	size := len(writer.out)
+	_, err := writer.Writer.Write(writer.out)
+	writer.out = writer.out[:0]
+
+	if writer.logger.Enabled(context.Background(), slog.LevelDebug) {
+		writer.logger.Debug("-> flushing messages", slog.Int("size", size))
+	}
+
 	return err
 }
 
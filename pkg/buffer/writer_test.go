@@ -3,7 +3,10 @@ package buffer
 import (
 	"bytes"
 	"errors"
+	"io"
+	"log/slog"
 	"math"
+	"net"
 	"testing"
 
 	"github.com/jeroenrinzema/psql-wire/pkg/types"
@@ -164,3 +167,213 @@ func TestWriteTypesErr(t *testing.T) {
 		}
 	})
 }
+
+// countingWriter counts the number of writes to the underlying writer
+type countingWriter struct {
+	bytes.Buffer
+	writes int
+}
+
+func (writer *countingWriter) Write(b []byte) (int, error) {
+	writer.writes++
+	return writer.Buffer.Write(b)
+}
+
+func TestBufferedWriter(t *testing.T) {
+	output := &countingWriter{}
+	writer := NewBufferedWriter(slogt.New(t), output, 64)
+
+	writer.Start(types.ServerDataRow)
+	writer.AddString("John Doe")
+	writer.AddNullTerminate()
+	err := writer.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output.writes != 0 {
+		t.Fatalf("unexpected writes %d, expected the message to be buffered", output.writes)
+	}
+
+	if writer.Buffered() != 14 {
+		t.Fatalf("unexpected buffered bytes %d, expected 14", writer.Buffered())
+	}
+
+	err = writer.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output.writes != 1 || output.Len() != 14 || writer.Buffered() != 0 {
+		t.Fatalf("unexpected writes %d (%d bytes), expected the message to be flushed", output.writes, output.Len())
+	}
+
+	// NOTE: flushing an empty output buffer should not write to the writer
+	err = writer.Flush()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output.writes != 1 {
+		t.Fatalf("unexpected writes %d, expected no additional writes", output.writes)
+	}
+}
+
+func TestBufferedWriterThreshold(t *testing.T) {
+	output := &countingWriter{}
+	writer := NewBufferedWriter(slogt.New(t), output, 64)
+
+	for range 10 {
+		writer.Start(types.ServerDataRow)
+		writer.AddString("John Doe")
+		writer.AddNullTerminate()
+		err := writer.End()
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// NOTE: the output buffer is flushed once 5 messages (70 bytes) are buffered
+	if output.writes != 2 || writer.Buffered() != 0 {
+		t.Fatalf("unexpected writes %d with %d buffered bytes, expected 2 writes", output.writes, writer.Buffered())
+	}
+
+	writer.Start(types.ServerDataRow)
+	writer.AddNullTerminate()
+	err := writer.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// NOTE: messages exceeding the threshold are written directly after
+	// flushing the pending messages.
+	writer.Start(types.ServerDataRow)
+	writer.AddBytes(make([]byte, 128))
+	err = writer.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if output.writes != 4 || writer.Buffered() != 0 {
+		t.Fatalf("unexpected writes %d with %d buffered bytes, expected 4 writes", output.writes, writer.Buffered())
+	}
+
+	if output.Len() != 140+6+133 {
+		t.Fatalf("unexpected output length %d", output.Len())
+	}
+}
+
+// BenchmarkWriterDataRows benchmarks writing a large result set to a TCP
+// connection with and without output buffering.
+func BenchmarkWriterDataRows(b *testing.B) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer listener.Close() //nolint:errcheck
+
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+
+		defer conn.Close()        //nolint:errcheck
+		io.Copy(io.Discard, conn) //nolint:errcheck
+	}()
+
+	conn, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	defer conn.Close() //nolint:errcheck
+
+	logger := slog.New(slog.DiscardHandler)
+	value := []byte("John Doe")
+	rows := 10000
+
+	run := func(b *testing.B, writer *Writer) {
+		b.ReportAllocs()
+		b.SetBytes(int64(rows * (1 + 4 + 2 + 4 + len(value))))
+
+		for b.Loop() {
+			for range rows {
+				writer.Start(types.ServerDataRow)
+				writer.AddInt16(1)
+				writer.AddInt32(int32(len(value)))
+				writer.AddBytes(value)
+				if err := writer.End(); err != nil {
+					b.Fatal(err)
+				}
+			}
+
+			if err := writer.Flush(); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	b.Run("unbuffered", func(b *testing.B) {
+		run(b, NewWriter(logger, conn))
+	})
+
+	b.Run("buffered", func(b *testing.B) {
+		run(b, NewBufferedWriter(logger, conn, DefaultFlushThreshold))
+	})
+}
+
+// encoderFn represents a append-style encoder function
+type encoderFn func(oid uint32, format int16, value any, buf []byte) ([]byte, error)
+
+func (fn encoderFn) Encode(oid uint32, format int16, value any, buf []byte) ([]byte, error) {
+	return fn(oid, format, value, buf)
+}
+
+func TestWriteValue(t *testing.T) {
+	unexpected := errors.New("unexpected value")
+	encoder := encoderFn(func(oid uint32, format int16, value any, buf []byte) ([]byte, error) {
+		switch value := value.(type) {
+		case nil:
+			return nil, nil
+		case string:
+			return append(buf, value...), nil
+		default:
+			return append(buf, "partial"...), unexpected
+		}
+	})
+
+	buffer := bytes.NewBuffer([]byte{})
+	writer := NewWriter(slogt.New(t), buffer)
+
+	writer.Start(types.ServerDataRow)
+	writer.AddInt16(3)
+
+	for _, value := range []any{"John Doe", nil, ""} {
+		err := writer.AddValue(encoder, 25, 0, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	err := writer.AddValue(encoder, 25, 0, 42)
+	if err != unexpected {
+		t.Fatalf("unexpected error %s, expected %s", err, unexpected)
+	}
+
+	err = writer.End()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []byte{byte(types.ServerDataRow), 0, 0, 0, 26, 0, 3}
+	expected = append(expected, 0, 0, 0, 8)
+	expected = append(expected, "John Doe"...)
+	expected = append(expected, 0xff, 0xff, 0xff, 0xff)
+	expected = append(expected, 0, 0, 0, 0)
+
+	if !bytes.Equal(buffer.Bytes(), expected) {
+		t.Fatalf("unexpected message %v, expected %v", buffer.Bytes(), expected)
+	}
+}
@@ -125,6 +125,38 @@ func (client *Client) Authenticate(t *testing.T) {
 	}
 }
 
+// BackendKeyData reads a BackendKeyData message from the server and returns
+// the process ID and secret key used to cancel queries.
+func (client *Client) BackendKeyData(t *testing.T) (processID int32, secretKey []byte) {
+	t.Log("awaiting backend key data")
+	defer t.Log("backend key data received")
+
+	typed, _, err := client.ReadTypedMsg()
+	require.NoError(t, err)
+	require.Equal(t, types.ServerBackendKeyData, typed, "unexpected message type %s, expected %s", typed, types.ServerBackendKeyData)
+
+	processID, err = client.GetInt32()
+	require.NoError(t, err)
+
+	return processID, append([]byte(nil), client.Msg...)
+}
+
+// CancelRequest writes a cancel request for the given process ID and secret
+// key. A cancel request is send over a new connection instead of a regular
+// startup message.
+func (client *Client) CancelRequest(t *testing.T, processID int32, secretKey []byte) {
+	t.Log("writing cancel request")
+
+	message := make([]byte, 12, 12+len(secretKey))
+	binary.BigEndian.PutUint32(message[0:4], uint32(len(message)+len(secretKey)))
+	binary.BigEndian.PutUint32(message[4:8], uint32(types.VersionCancel))
+	binary.BigEndian.PutUint32(message[8:12], uint32(processID))
+	message = append(message, secretKey...)
+
+	_, err := client.conn.Write(message)
+	require.NoError(t, err)
+}
+
 // ReadyForQuery awaits till the underlaying network connection returns a ready
 // for query message carrying the given transaction status byte. This message
 // indicates that the server is ready to accept a new typed message to execute
@@ -28,7 +28,7 @@ var (
 	// VersionLatest is the highest protocol version implemented by this
 	// library. Clients requesting a newer minor version are negotiated back
 	// down to this version through a NegotiateProtocolVersion message.
-	VersionLatest = Version30
+	VersionLatest = Version32
 )
 
 // Major returns the major protocol version number ((major << 16) | minor).
@@ -0,0 +1,486 @@
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultProxyHeaderTimeout is the maximum duration allowed for a trusted
+// proxy to send the PROXY protocol header.
+const DefaultProxyHeaderTimeout = 5 * time.Second
+
+var (
+	// proxyV1Prefix is the prefix of a PROXY protocol v1 header.
+	proxyV1Prefix = []byte("PROXY ")
+	// proxyV2Signature is the signature of a PROXY protocol v2 header.
+	proxyV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+)
+
+const (
+	// proxyV1MaxLength is the maximum length of a PROXY protocol v1 header
+	// including the CRLF.
+	proxyV1MaxLength = 107
+	// proxyV2HeaderLength is the length of the fixed part of a PROXY protocol
+	// v2 header.
+	proxyV2HeaderLength = 16
+)
+
+// ProxyCommand represents the command of a PROXY protocol header.
+type ProxyCommand uint8
+
+const (
+	// ProxyLocal indicates that the connection has been established by the
+	// proxy itself (ex: health checks). The connection addresses are used.
+	ProxyLocal ProxyCommand = 0x0
+	// ProxyProxy indicates that the connection has been established on
+	// behalf of another node.
+	ProxyProxy ProxyCommand = 0x1
+)
+
+// ProxyTLVType represents the type of a PROXY protocol v2 TLV field.
+type ProxyTLVType uint8
+
+// PROXY protocol v2 TLV types.
+// https://www.haproxy.org/download/2.9/doc/proxy-protocol.txt
+const (
+	ProxyTLVALPN      ProxyTLVType = 0x01
+	ProxyTLVAuthority ProxyTLVType = 0x02
+	ProxyTLVCRC32C    ProxyTLVType = 0x03
+	ProxyTLVNoop      ProxyTLVType = 0x04
+	ProxyTLVUniqueID  ProxyTLVType = 0x05
+	ProxyTLVSSL       ProxyTLVType = 0x20
+	ProxyTLVNetNS     ProxyTLVType = 0x30
+	ProxyTLVAWS       ProxyTLVType = 0xEA
+	ProxyTLVAzure     ProxyTLVType = 0xEE
+	ProxyTLVGCP       ProxyTLVType = 0xE0
+)
+
+// PROXY protocol v2 SSL sub-TLV types.
+const (
+	ProxyTLVSSLVersion ProxyTLVType = 0x21
+	ProxyTLVSSLCN      ProxyTLVType = 0x22
+	ProxyTLVSSLCipher  ProxyTLVType = 0x23
+	ProxyTLVSSLSigAlg  ProxyTLVType = 0x24
+	ProxyTLVSSLKeyAlg  ProxyTLVType = 0x25
+)
+
+// proxyAWSVPCEndpointID is the AWS TLV subtype containing the VPC endpoint id.
+const proxyAWSVPCEndpointID = 0x01
+
+// ProxyTLV represents a single TLV field of a PROXY protocol v2 header.
+type ProxyTLV struct {
+	Type  ProxyTLVType
+	Value []byte
+}
+
+// ProxyHeader represents a PROXY protocol header sent by a trusted proxy.
+type ProxyHeader struct {
+	// Version is the PROXY protocol version (1 or 2).
+	Version int
+	Command ProxyCommand
+	// Source and Destination contain the original connection addresses. Both
+	// are nil for LOCAL commands and unknown address families.
+	Source      net.Addr
+	Destination net.Addr
+	// TLVs contains the TLV fields of a PROXY protocol v2 header.
+	TLVs []ProxyTLV
+}
+
+// TLV returns the value of the first TLV field of the given type.
+func (header *ProxyHeader) TLV(typed ProxyTLVType) ([]byte, bool) {
+	for _, tlv := range header.TLVs {
+		if tlv.Type == typed {
+			return tlv.Value, true
+		}
+	}
+
+	return nil, false
+}
+
+// ProxySSL represents the SSL information of a connection terminated by the
+// proxy (PP2_TYPE_SSL).
+type ProxySSL struct {
+	// Client contains the PP2_CLIENT_* flags.
+	Client uint8
+	// Verify is zero if the client presented a certificate which has been
+	// successfully verified.
+	Verify     uint32
+	Version    string
+	CommonName string
+	Cipher     string
+	SigAlg     string
+	KeyAlg     string
+}
+
+// SSL returns the SSL information included inside the header.
+func (header *ProxyHeader) SSL() (*ProxySSL, bool) {
+	value, ok := header.TLV(ProxyTLVSSL)
+	if !ok || len(value) < 5 {
+		return nil, false
+	}
+
+	ssl := &ProxySSL{
+		Client: value[0],
+		Verify: binary.BigEndian.Uint32(value[1:5]),
+	}
+
+	tlvs, err := parseProxyTLVs(value[5:])
+	if err != nil {
+		return nil, false
+	}
+
+	for _, tlv := range tlvs {
+		switch tlv.Type {
+		case ProxyTLVSSLVersion:
+			ssl.Version = string(tlv.Value)
+		case ProxyTLVSSLCN:
+			ssl.CommonName = string(tlv.Value)
+		case ProxyTLVSSLCipher:
+			ssl.Cipher = string(tlv.Value)
+		case ProxyTLVSSLSigAlg:
+			ssl.SigAlg = string(tlv.Value)
+		case ProxyTLVSSLKeyAlg:
+			ssl.KeyAlg = string(tlv.Value)
+		}
+	}
+
+	return ssl, true
+}
+
+// AWSVPCEndpointID returns the AWS VPC endpoint id included by AWS
+// PrivateLink and Network Load Balancers.
+func (header *ProxyHeader) AWSVPCEndpointID() (string, bool) {
+	for _, tlv := range header.TLVs {
+		if tlv.Type == ProxyTLVAWS && len(tlv.Value) > 0 && tlv.Value[0] == proxyAWSVPCEndpointID {
+			return string(tlv.Value[1:]), true
+		}
+	}
+
+	return "", false
+}
+
+// proxyConn represents a connection accepted through a proxy. The connection
+// addresses are replaced with the addresses included inside the PROXY
+// protocol header.
+type proxyConn struct {
+	net.Conn
+	reader *bufio.Reader
+	header *ProxyHeader
+}
+
+func (conn *proxyConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+func (conn *proxyConn) RemoteAddr() net.Addr {
+	if conn.header.Source != nil {
+		return conn.header.Source
+	}
+
+	return conn.Conn.RemoteAddr()
+}
+
+func (conn *proxyConn) LocalAddr() net.Addr {
+	if conn.header.Destination != nil {
+		return conn.header.Destination
+	}
+
+	return conn.Conn.LocalAddr()
+}
+
+// trustedProxy checks whether the given connection has been established by a
+// trusted proxy.
+func (srv *Server) trustedProxy(conn net.Conn) bool {
+	ip := remoteIP(conn.RemoteAddr())
+	if ip == nil {
+		return false
+	}
+
+	for _, network := range srv.ProxyTrustedNetworks {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// readProxyHeader reads the PROXY protocol header sent by a trusted proxy. The
+// returned connection reports the addresses included inside the header.
+// Trusted proxies are required to send a header.
+func (srv *Server) readProxyHeader(conn net.Conn) (*proxyConn, error) {
+	err := conn.SetReadDeadline(time.Now().Add(DefaultProxyHeaderTimeout))
+	if err != nil {
+		return nil, err
+	}
+
+	reader := bufio.NewReader(conn)
+	header, err := readProxyHeader(reader)
+	if err != nil {
+		return nil, err
+	}
+
+	err = conn.SetReadDeadline(time.Time{})
+	if err != nil {
+		return nil, err
+	}
+
+	return &proxyConn{Conn: conn, reader: reader, header: header}, nil
+}
+
+// readProxyHeader reads a PROXY protocol v1 or v2 header from the given reader.
+func readProxyHeader(reader *bufio.Reader) (*ProxyHeader, error) {
+	signature, err := reader.Peek(len(proxyV2Signature))
+	if err != nil {
+		return nil, fmt.Errorf("unable to read PROXY protocol header: %w", err)
+	}
+
+	switch {
+	case bytes.Equal(signature, proxyV2Signature):
+		return readProxyHeaderV2(reader)
+	case bytes.HasPrefix(signature, proxyV1Prefix):
+		return readProxyHeaderV1(reader)
+	default:
+		return nil, errors.New("connection from trusted proxy did not start with a PROXY protocol header")
+	}
+}
+
+// readProxyHeaderV1 reads a human readable PROXY protocol v1 header.
+//
+// PROXY TCP4 192.168.0.1 192.168.0.11 56324 443\r\n
+func readProxyHeaderV1(reader *bufio.Reader) (*ProxyHeader, error) {
+	line := make([]byte, 0, proxyV1MaxLength)
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, fmt.Errorf("unable to read PROXY protocol header: %w", err)
+		}
+
+		line = append(line, b)
+		if b == '\n' {
+			break
+		}
+
+		if len(line) >= proxyV1MaxLength {
+			return nil, errors.New("malformed PROXY protocol v1 header: header too long")
+		}
+	}
+
+	text, ok := strings.CutSuffix(string(line), "\r\n")
+	if !ok {
+		return nil, errors.New("malformed PROXY protocol v1 header: missing CRLF")
+	}
+
+	header := &ProxyHeader{Version: 1, Command: ProxyProxy}
+	fields := strings.Split(text, " ")
+	if len(fields) >= 2 && fields[1] == "UNKNOWN" {
+		return header, nil
+	}
+
+	if len(fields) != 6 {
+		return nil, errors.New("malformed PROXY protocol v1 header: unexpected number of fields")
+	}
+
+	source := net.ParseIP(fields[2])
+	destination := net.ParseIP(fields[3])
+	if source == nil || destination == nil {
+		return nil, errors.New("malformed PROXY protocol v1 header: invalid address")
+	}
+
+	switch fields[1] {
+	case "TCP4":
+		if source.To4() == nil || destination.To4() == nil {
+			return nil, errors.New("malformed PROXY protocol v1 header: invalid IPv4 address")
+		}
+	case "TCP6":
+		if source.To4() != nil || destination.To4() != nil {
+			return nil, errors.New("malformed PROXY protocol v1 header: invalid IPv6 address")
+		}
+	default:
+		return nil, fmt.Errorf("malformed PROXY protocol v1 header: unsupported protocol %q", fields[1])
+	}
+
+	sourcePort, err := parseProxyPort(fields[4])
+	if err != nil {
+		return nil, err
+	}
+
+	destinationPort, err := parseProxyPort(fields[5])
+	if err != nil {
+		return nil, err
+	}
+
+	header.Source = &net.TCPAddr{IP: source, Port: sourcePort}
+	header.Destination = &net.TCPAddr{IP: destination, Port: destinationPort}
+	return header, nil
+}
+
+// parseProxyPort parses the given PROXY protocol v1 port.
+func parseProxyPort(value string) (int, error) {
+	port, err := strconv.ParseUint(value, 10, 16)
+	if err != nil || (len(value) > 1 && value[0] == '0') {
+		return 0, fmt.Errorf("malformed PROXY protocol v1 header: invalid port %q", value)
+	}
+
+	return int(port), nil
+}
+
+// readProxyHeaderV2 reads a binary PROXY protocol v2 header.
+func readProxyHeaderV2(reader *bufio.Reader) (*ProxyHeader, error) {
+	raw := make([]byte, proxyV2HeaderLength)
+	err := readProxyBytes(reader, raw)
+	if err != nil {
+		return nil, err
+	}
+
+	if raw[12]>>4 != 0x2 {
+		return nil, fmt.Errorf("malformed PROXY protocol v2 header: unsupported version %d", raw[12]>>4)
+	}
+
+	header := &ProxyHeader{Version: 2, Command: ProxyCommand(raw[12] & 0x0F)}
+	if header.Command != ProxyLocal && header.Command != ProxyProxy {
+		return nil, fmt.Errorf("malformed PROXY protocol v2 header: unsupported command %d", header.Command)
+	}
+
+	family := raw[13]
+	length := int(binary.BigEndian.Uint16(raw[14:16]))
+
+	raw = append(raw, make([]byte, length)...)
+	err = readProxyBytes(reader, raw[proxyV2HeaderLength:])
+	if err != nil {
+		return nil, err
+	}
+
+	payload := raw[proxyV2HeaderLength:]
+
+	var addresses int
+	switch family >> 4 {
+	case 0x1: // AF_INET
+		addresses = 12
+		if len(payload) < addresses {
+			return nil, errors.New("malformed PROXY protocol v2 header: address block too short")
+		}
+
+		header.Source = proxyInetAddr(family, payload[0:4], payload[8:10])
+		header.Destination = proxyInetAddr(family, payload[4:8], payload[10:12])
+	case 0x2: // AF_INET6
+		addresses = 36
+		if len(payload) < addresses {
+			return nil, errors.New("malformed PROXY protocol v2 header: address block too short")
+		}
+
+		header.Source = proxyInetAddr(family, payload[0:16], payload[32:34])
+		header.Destination = proxyInetAddr(family, payload[16:32], payload[34:36])
+	case 0x3: // AF_UNIX
+		addresses = 216
+		if len(payload) < addresses {
+			return nil, errors.New("malformed PROXY protocol v2 header: address block too short")
+		}
+
+		header.Source = &net.UnixAddr{Name: string(bytes.TrimRight(payload[0:108], "\x00")), Net: "unix"}
+		header.Destination = &net.UnixAddr{Name: string(bytes.TrimRight(payload[108:216], "\x00")), Net: "unix"}
+	}
+
+	header.TLVs, err = parseProxyTLVs(payload[addresses:])
+	if err != nil {
+		return nil, err
+	}
+
+	offset := proxyV2HeaderLength + addresses
+	for _, tlv := range header.TLVs {
+		if tlv.Type == ProxyTLVCRC32C {
+			err = verifyProxyChecksum(raw, offset+3, tlv.Value)
+			if err != nil {
+				return nil, err
+			}
+		}
+
+		offset += 3 + len(tlv.Value)
+	}
+
+	// NOTE: the addresses of LOCAL connections should be ignored.
+	if header.Command == ProxyLocal {
+		header.Source = nil
+		header.Destination = nil
+	}
+
+	return header, nil
+}
+
+// readProxyBytes reads exactly len(b) bytes from the given reader.
+func readProxyBytes(reader *bufio.Reader, b []byte) error {
+	_, err := io.ReadFull(reader, b)
+	if err != nil {
+		return fmt.Errorf("unable to read PROXY protocol header: %w", err)
+	}
+
+	return nil
+}
+
+// proxyInetAddr constructs the network address of the given IP and port. UDP
+// addresses are returned for datagram transport protocols.
+func proxyInetAddr(family byte, ip []byte, port []byte) net.Addr {
+	addr := net.IP(bytes.Clone(ip))
+	value := int(binary.BigEndian.Uint16(port))
+	if family&0x0F == 0x2 {
+		return &net.UDPAddr{IP: addr, Port: value}
+	}
+
+	return &net.TCPAddr{IP: addr, Port: value}
+}
+
+// parseProxyTLVs parses the given PROXY protocol v2 TLV fields.
+func parseProxyTLVs(data []byte) ([]ProxyTLV, error) {
+	var tlvs []ProxyTLV
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return nil, errors.New("malformed PROXY protocol v2 header: truncated TLV")
+		}
+
+		length := int(binary.BigEndian.Uint16(data[1:3]))
+		if len(data) < 3+length {
+			return nil, errors.New("malformed PROXY protocol v2 header: truncated TLV")
+		}
+
+		tlvs = append(tlvs, ProxyTLV{
+			Type:  ProxyTLVType(data[0]),
+			Value: data[3 : 3+length],
+		})
+
+		data = data[3+length:]
+	}
+
+	return tlvs, nil
+}
+
+// proxyCRC32C is the CRC32c table used to verify PROXY protocol v2 headers.
+var proxyCRC32C = crc32.MakeTable(crc32.Castagnoli)
+
+// verifyProxyChecksum verifies the CRC32c checksum of the given PROXY protocol
+// v2 header. The checksum is computed over the entire header with the value of
+// the checksum TLV, located at the given offset, replaced with zeros.
+func verifyProxyChecksum(raw []byte, offset int, checksum []byte) error {
+	if len(checksum) != 4 {
+		return errors.New("malformed PROXY protocol v2 header: invalid CRC32c checksum length")
+	}
+
+	expected := binary.BigEndian.Uint32(checksum)
+
+	data := bytes.Clone(raw)
+	clear(data[offset : offset+4])
+
+	if crc32.Checksum(data, proxyCRC32C) != expected {
+		return errors.New("PROXY protocol v2 header checksum mismatch")
+	}
+
+	return nil
+}
@@ -0,0 +1,285 @@
+package wire
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"hash/crc32"
+	"net"
+	"testing"
+
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/mock"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// proxyTLVBytes encodes the given TLV fields.
+func proxyTLVBytes(tlvs ...ProxyTLV) []byte {
+	var data []byte
+	for _, tlv := range tlvs {
+		data = append(data, byte(tlv.Type))
+		data = binary.BigEndian.AppendUint16(data, uint16(len(tlv.Value)))
+		data = append(data, tlv.Value...)
+	}
+
+	return data
+}
+
+// proxyV2Header constructs a PROXY protocol v2 header using the given command,
+// address family, address block and TLV fields.
+func proxyV2Header(command ProxyCommand, family byte, addresses []byte, tlvs ...ProxyTLV) []byte {
+	payload := append(bytes.Clone(addresses), proxyTLVBytes(tlvs...)...)
+
+	header := bytes.Clone(proxyV2Signature)
+	header = append(header, 0x20|byte(command), family)
+	header = binary.BigEndian.AppendUint16(header, uint16(len(payload)))
+	return append(header, payload...)
+}
+
+// proxyV2Checksum fills in the value of the trailing CRC32c TLV of the given
+// header.
+func proxyV2Checksum(header []byte) []byte {
+	checksum := crc32.Checksum(header, crc32.MakeTable(crc32.Castagnoli))
+	binary.BigEndian.PutUint32(header[len(header)-4:], checksum)
+	return header
+}
+
+func TestReadProxyHeader(t *testing.T) {
+	t.Parallel()
+
+	ipv4 := []byte{192, 168, 0, 1, 10, 0, 0, 1, 0xDC, 0x04, 0x01, 0xBB}
+	ipv6 := append(append(net.ParseIP("2001:db8::1").To16(), net.ParseIP("2001:db8::2").To16()...), 0xDC, 0x04, 0x15, 0x38)
+
+	ssl := append([]byte{0x07, 0, 0, 0, 0}, proxyTLVBytes(
+		ProxyTLV{Type: ProxyTLVSSLVersion, Value: []byte("TLSv1.3")},
+		ProxyTLV{Type: ProxyTLVSSLCN, Value: []byte("client")},
+	)...)
+
+	tests := map[string]struct {
+		header      []byte
+		version     int
+		command     ProxyCommand
+		source      string
+		destination string
+		err         bool
+	}{
+		"v1 tcp4": {
+			header:      []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\r\n"),
+			version:     1,
+			command:     ProxyProxy,
+			source:      "192.168.0.1:56324",
+			destination: "10.0.0.1:443",
+		},
+		"v1 tcp6": {
+			header:      []byte("PROXY TCP6 2001:db8::1 2001:db8::2 56324 5432\r\n"),
+			version:     1,
+			command:     ProxyProxy,
+			source:      "[2001:db8::1]:56324",
+			destination: "[2001:db8::2]:5432",
+		},
+		"v1 unknown": {
+			header:  []byte("PROXY UNKNOWN\r\n"),
+			version: 1,
+			command: ProxyProxy,
+		},
+		"v1 missing CRLF": {
+			header: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 443\n"),
+			err:    true,
+		},
+		"v1 mismatched family": {
+			header: []byte("PROXY TCP4 2001:db8::1 2001:db8::2 56324 5432\r\n"),
+			err:    true,
+		},
+		"v1 invalid port": {
+			header: []byte("PROXY TCP4 192.168.0.1 10.0.0.1 065536 443\r\n"),
+			err:    true,
+		},
+		"v2 tcp4": {
+			header:      proxyV2Header(ProxyProxy, 0x11, ipv4),
+			version:     2,
+			command:     ProxyProxy,
+			source:      "192.168.0.1:56324",
+			destination: "10.0.0.1:443",
+		},
+		"v2 tcp6": {
+			header:      proxyV2Header(ProxyProxy, 0x21, ipv6),
+			version:     2,
+			command:     ProxyProxy,
+			source:      "[2001:db8::1]:56324",
+			destination: "[2001:db8::2]:5432",
+		},
+		"v2 local": {
+			header:  proxyV2Header(ProxyLocal, 0x11, ipv4),
+			version: 2,
+			command: ProxyLocal,
+		},
+		"v2 tlvs": {
+			header: proxyV2Header(ProxyProxy, 0x11, ipv4,
+				ProxyTLV{Type: ProxyTLVSSL, Value: ssl},
+				ProxyTLV{Type: ProxyTLVAWS, Value: append([]byte{0x01}, "vpce-0123456789abcdef0"...)},
+			),
+			version:     2,
+			command:     ProxyProxy,
+			source:      "192.168.0.1:56324",
+			destination: "10.0.0.1:443",
+		},
+		"v2 valid checksum": {
+			header:      proxyV2Checksum(proxyV2Header(ProxyProxy, 0x11, ipv4, ProxyTLV{Type: ProxyTLVCRC32C, Value: make([]byte, 4)})),
+			version:     2,
+			command:     ProxyProxy,
+			source:      "192.168.0.1:56324",
+			destination: "10.0.0.1:443",
+		},
+		"v2 invalid checksum": {
+			header: proxyV2Header(ProxyProxy, 0x11, ipv4, ProxyTLV{Type: ProxyTLVCRC32C, Value: []byte{1, 2, 3, 4}}),
+			err:    true,
+		},
+		"v2 truncated address block": {
+			header: proxyV2Header(ProxyProxy, 0x11, ipv4[:8]),
+			err:    true,
+		},
+		"v2 truncated tlv": {
+			header: proxyV2Header(ProxyProxy, 0x11, append(bytes.Clone(ipv4), byte(ProxyTLVNoop), 0x00, 0x10)),
+			err:    true,
+		},
+		"missing header": {
+			header: []byte("\x00\x00\x00\x08\x04\xd2\x16\x2f"),
+			err:    true,
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			trailer := []byte("startup")
+			reader := bufio.NewReader(bytes.NewReader(append(bytes.Clone(test.header), trailer...)))
+
+			header, err := readProxyHeader(reader)
+			if test.err {
+				require.Error(t, err)
+				return
+			}
+
+			require.NoError(t, err)
+			assert.Equal(t, test.version, header.Version)
+			assert.Equal(t, test.command, header.Command)
+
+			if test.source == "" {
+				assert.Nil(t, header.Source)
+				assert.Nil(t, header.Destination)
+			} else {
+				assert.Equal(t, test.source, header.Source.String())
+				assert.Equal(t, test.destination, header.Destination.String())
+			}
+
+			// NOTE: the bytes following the header should be left untouched
+			remaining := make([]byte, len(trailer))
+			_, err = reader.Read(remaining)
+			require.NoError(t, err)
+			assert.Equal(t, trailer, remaining)
+		})
+	}
+
+	t.Run("v2 tlv accessors", func(t *testing.T) {
+		reader := bufio.NewReader(bytes.NewReader(tests["v2 tlvs"].header))
+		header, err := readProxyHeader(reader)
+		require.NoError(t, err)
+
+		endpoint, ok := header.AWSVPCEndpointID()
+		require.True(t, ok)
+		assert.Equal(t, "vpce-0123456789abcdef0", endpoint)
+
+		info, ok := header.SSL()
+		require.True(t, ok)
+		assert.Equal(t, uint8(0x07), info.Client)
+		assert.Equal(t, uint32(0), info.Verify)
+		assert.Equal(t, "TLSv1.3", info.Version)
+		assert.Equal(t, "client", info.CommonName)
+	})
+}
+
+func TestProxyProtocol(t *testing.T) {
+	t.Parallel()
+
+	type connection struct {
+		addr   net.Addr
+		header *ProxyHeader
+	}
+
+	connections := make(chan connection, 1)
+	auth := func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (context.Context, error) {
+		connections <- connection{addr: RemoteAddress(ctx), header: ProxyProtocolHeader(ctx)}
+		return ctx, writeAuthType(writer, authOK)
+	}
+
+	server, err := NewServer(nil, Logger(slogt.New(t)), SessionAuthStrategy(auth), ProxyProtocol("127.0.0.0/8"))
+	require.NoError(t, err)
+	address := TListenAndServe(t, server)
+
+	t.Run("trusted proxy", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address.String())
+		require.NoError(t, err)
+
+		_, err = conn.Write([]byte("PROXY TCP4 192.168.0.1 10.0.0.1 56324 5432\r\n"))
+		require.NoError(t, err)
+
+		client := mock.NewClient(t, conn)
+		client.Handshake(t)
+		client.Authenticate(t)
+		client.ReadyForQuery(t, types.ServerIdle)
+
+		result := <-connections
+		assert.Equal(t, "192.168.0.1:56324", result.addr.String())
+		require.NotNil(t, result.header)
+		assert.Equal(t, "10.0.0.1:5432", result.header.Destination.String())
+
+		client.Close(t)
+	})
+
+	t.Run("missing header", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address.String())
+		require.NoError(t, err)
+
+		client := mock.NewClient(t, conn)
+		client.Handshake(t)
+
+		_, _, err = client.ReadTypedMsg()
+		require.Error(t, err)
+	})
+}
+
+func TestProxyProtocolUntrusted(t *testing.T) {
+	t.Parallel()
+
+	connections := make(chan net.Addr, 1)
+	auth := func(ctx context.Context, writer *buffer.Writer, reader *buffer.Reader) (context.Context, error) {
+		assert.Nil(t, ProxyProtocolHeader(ctx))
+		connections <- RemoteAddress(ctx)
+		return ctx, writeAuthType(writer, authOK)
+	}
+
+	server, err := NewServer(nil, Logger(slogt.New(t)), SessionAuthStrategy(auth), ProxyProtocol("10.0.0.0/8"))
+	require.NoError(t, err)
+	address := TListenAndServe(t, server)
+
+	conn, err := net.Dial("tcp", address.String())
+	require.NoError(t, err)
+
+	client := mock.NewClient(t, conn)
+	client.Handshake(t)
+	client.Authenticate(t)
+	client.ReadyForQuery(t, types.ServerIdle)
+
+	assert.Equal(t, conn.LocalAddr().String(), (<-connections).String())
+	client.Close(t)
+}
+
+func TestProxyProtocolOption(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewServer(nil, ProxyProtocol("10.0.0.1"))
+	require.Error(t, err)
+}
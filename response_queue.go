@@ -3,6 +3,8 @@ package wire
 import (
 	"bytes"
 	"context"
+
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
 )
 
 // ResponseEventKind represents the type of event in the ResponseQueue
@@ -45,8 +47,43 @@ type ResponseEvent struct {
 // executeResult holds the raw wire bytes or error produced by an async
 // portal execution in the parallel pipeline.
 type executeResult struct {
-	buf *bytes.Buffer
-	err error
+	buf     *bytes.Buffer
+	err     error
+	scratch *scratchWriter
+}
+
+// maxScratchSize represents the maximum capacity of scratch buffers which are
+// reused. Larger buffers are released to avoid retaining large allocations.
+const maxScratchSize = 1 << 20 // 1048576 bytes
+
+// scratchWriter represents a reusable message writer capturing the wire output
+// of a pipelined execution.
+type scratchWriter struct {
+	buf    bytes.Buffer
+	writer *buffer.Writer
+}
+
+// scratchWriter returns a message writer capturing the written messages inside
+// a per-session scratch buffer. The scratch buffer is reused once released.
+func (srv *Session) scratchWriter() *scratchWriter {
+	scratch, ok := srv.scratch.Get().(*scratchWriter)
+	if !ok {
+		scratch = &scratchWriter{}
+		scratch.writer = buffer.NewWriter(srv.logger, &scratch.buf)
+	}
+
+	scratch.buf.Reset()
+	return scratch
+}
+
+// releaseScratch returns the given scratch writer to the session for it to be
+// reused by upcoming pipelined executions.
+func (srv *Session) releaseScratch(scratch *scratchWriter) {
+	if scratch == nil || scratch.buf.Cap() > maxScratchSize {
+		return
+	}
+
+	srv.scratch.Put(scratch)
 }
 
 // NewParseCompleteEvent creates a ParseComplete response event
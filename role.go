@@ -0,0 +1,319 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"maps"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// Role represents a database role. Roles are attached to connections by
+// authentication strategies using [WithRole] or resolved using the configured
+// [RoleLookupFn] once the client has been authenticated.
+type Role struct {
+	Name      string
+	Superuser bool
+	// MemberOf contains the names of the roles this role is a direct member
+	// of. Memberships are resolved recursively using the configured
+	// [RoleLookupFn] when changing roles.
+	MemberOf []string
+	// Settings contains the default settings of the role. The settings are
+	// reported to the client as server parameters once the client has been
+	// authenticated.
+	Settings Parameters
+}
+
+// RoleLookupFn resolves the role with the given name. Nil is returned when
+// the role does not exist.
+type RoleLookupFn func(ctx context.Context, name string) (*Role, error)
+
+// WithRole attaches the given role to the connection context. Authentication
+// strategies should call this method to attach the role of the authenticated
+// user to the connection.
+func WithRole(ctx context.Context, role *Role) context.Context {
+	return context.WithValue(ctx, ctxRole, role)
+}
+
+// roleState holds the roles of a single session.
+type roleState struct {
+	mu            sync.Mutex
+	authenticated *Role
+	session       *Role
+	current       *Role
+	// pending contains the parameter status messages which should be sent to
+	// the client before the next ReadyForQuery message.
+	pending Parameters
+}
+
+// newRoleState constructs a new role state for the given authenticated role.
+func newRoleState(role *Role) *roleState {
+	return &roleState{
+		authenticated: role,
+		session:       role,
+		current:       role,
+	}
+}
+
+// queue queues the role parameters to be sent to the client.
+func (state *roleState) queue() {
+	if state.pending == nil {
+		state.pending = make(Parameters, 2)
+	}
+
+	state.pending[ParamIsSuperuser] = buffer.EncodeBoolean(state.current.Superuser)
+	state.pending[ParamSessionAuthorization] = state.session.Name
+}
+
+// flush writes all pending parameter status messages to the client.
+func (state *roleState) flush(writer *buffer.Writer) error {
+	state.mu.Lock()
+	pending := state.pending
+	state.pending = nil
+	state.mu.Unlock()
+
+	for _, key := range slices.Sorted(maps.Keys(pending)) {
+		writer.Start(types.ServerParameterStatus)
+		writer.AddString(string(key))
+		writer.AddNullTerminate()
+		writer.AddString(pending[key])
+		writer.AddNullTerminate()
+		err := writer.End()
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// resolveRole resolves the role of the authenticated user if no role has
+// been attached by the authentication strategy. A role only containing the
+// username is constructed if no role lookup has been configured.
+func (srv *Server) resolveRole(ctx context.Context) (context.Context, error) {
+	if role, ok := ctx.Value(ctxRole).(*Role); ok && role != nil {
+		return ctx, nil
+	}
+
+	username := ClientParameters(ctx)[ParamUsername]
+	if srv.RoleLookup == nil {
+		return WithRole(ctx, &Role{Name: username}), nil
+	}
+
+	role, err := srv.RoleLookup(ctx, username)
+	if err != nil {
+		return ctx, err
+	}
+
+	if role == nil {
+		err := fmt.Errorf("role %q does not exist", username)
+		return ctx, pgerror.WithSeverity(pgerror.WithCode(err, codes.InvalidAuthorizationSpecification), pgerror.LevelFatal)
+	}
+
+	return WithRole(ctx, role), nil
+}
+
+// sessionRoles returns the role state of the session inside the given
+// context. Nil is returned if no session has been started yet.
+func sessionRoles(ctx context.Context) *roleState {
+	session, ok := GetSession(ctx)
+	if !ok || session.roles == nil {
+		return nil
+	}
+
+	return session.roles
+}
+
+// AuthenticatedRole returns the role of the authenticated user. Nil is
+// returned if the connection has not been authenticated yet.
+func AuthenticatedRole(ctx context.Context) *Role {
+	if state := sessionRoles(ctx); state != nil {
+		return state.authenticated
+	}
+
+	role, _ := ctx.Value(ctxRole).(*Role)
+	return role
+}
+
+// SessionRole returns the session role (session_user) of the given
+// connection context. The session role equals the authenticated role unless
+// changed using SET SESSION AUTHORIZATION ([SetSessionAuthorization]).
+func SessionRole(ctx context.Context) *Role {
+	state := sessionRoles(ctx)
+	if state == nil {
+		return AuthenticatedRole(ctx)
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.session
+}
+
+// CurrentRole returns the current role (current_user) of the given
+// connection context used for permission checking. The current role equals
+// the session role unless changed using SET ROLE ([SetRole]).
+func CurrentRole(ctx context.Context) *Role {
+	state := sessionRoles(ctx)
+	if state == nil {
+		return AuthenticatedRole(ctx)
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+	return state.current
+}
+
+// SetRole changes the current role of the session, equivalent to SET ROLE.
+// The session role has to be a superuser or a (indirect) member of the given
+// role. The current role is reset to the session role when the name is empty
+// or NONE (RESET ROLE). The updated is_superuser parameter is reported to the
+// client before the next ReadyForQuery message.
+func SetRole(ctx context.Context, name string) error {
+	state := sessionRoles(ctx)
+	if state == nil {
+		return errors.New("no active session available")
+	}
+
+	state.mu.Lock()
+	session := state.session
+	state.mu.Unlock()
+
+	role := session
+	if name != "" && !strings.EqualFold(name, "none") {
+		var err error
+		role, err = lookupRole(ctx, session, name)
+		if err != nil {
+			return err
+		}
+
+		if !session.Superuser {
+			member, err := isRoleMember(ctx, session, role.Name)
+			if err != nil {
+				return err
+			}
+
+			if !member {
+				err := fmt.Errorf("permission denied to set role %q", name)
+				return pgerror.WithCode(err, codes.InsufficientPrivilege)
+			}
+		}
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.current = role
+	state.queue()
+	return nil
+}
+
+// SetSessionAuthorization changes the session role of the session,
+// equivalent to SET SESSION AUTHORIZATION. Only superusers are allowed to
+// change the session role. The current role is reset to the new session role.
+// The session role is reset to the authenticated role when the name is empty
+// or DEFAULT (RESET SESSION AUTHORIZATION). The updated is_superuser and
+// session_authorization parameters are reported to the client before the next
+// ReadyForQuery message.
+func SetSessionAuthorization(ctx context.Context, name string) error {
+	state := sessionRoles(ctx)
+	if state == nil {
+		return errors.New("no active session available")
+	}
+
+	authenticated := state.authenticated
+
+	role := authenticated
+	if name != "" && !strings.EqualFold(name, "default") {
+		var err error
+		role, err = lookupRole(ctx, authenticated, name)
+		if err != nil {
+			return err
+		}
+
+		if role.Name != authenticated.Name && !authenticated.Superuser {
+			err := errors.New("permission denied to set session authorization")
+			return pgerror.WithCode(err, codes.InsufficientPrivilege)
+		}
+	}
+
+	state.mu.Lock()
+	defer state.mu.Unlock()
+
+	state.session = role
+	state.current = role
+	state.queue()
+	return nil
+}
+
+// lookupRole resolves the role with the given name using the configured role
+// lookup. The given fallback role is returned if its name matches.
+func lookupRole(ctx context.Context, fallback *Role, name string) (*Role, error) {
+	if fallback != nil && fallback.Name == name {
+		return fallback, nil
+	}
+
+	session, ok := GetSession(ctx)
+	if ok && session.RoleLookup != nil {
+		role, err := session.RoleLookup(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+
+		if role != nil {
+			return role, nil
+		}
+	}
+
+	err := fmt.Errorf("role %q does not exist", name)
+	return nil, pgerror.WithCode(err, codes.InvalidParameterValue)
+}
+
+// isRoleMember checks whether the given role is a (indirect) member of the
+// target role. Memberships are resolved recursively using the configured role
+// lookup.
+func isRoleMember(ctx context.Context, role *Role, target string) (bool, error) {
+	if role.Name == target {
+		return true, nil
+	}
+
+	visited := map[string]bool{role.Name: true}
+	queue := slices.Clone(role.MemberOf)
+
+	for len(queue) > 0 {
+		name := queue[0]
+		queue = queue[1:]
+
+		if name == target {
+			return true, nil
+		}
+
+		if visited[name] {
+			continue
+		}
+
+		visited[name] = true
+
+		session, ok := GetSession(ctx)
+		if !ok || session.RoleLookup == nil {
+			continue
+		}
+
+		parent, err := session.RoleLookup(ctx, name)
+		if err != nil {
+			return false, err
+		}
+
+		if parent != nil {
+			queue = append(queue, parent.MemberOf...)
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,151 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestRoles(t *testing.T) {
+	t.Parallel()
+
+	roles := map[string]*Role{
+		"admin":   {Name: "admin", Superuser: true},
+		"alice":   {Name: "alice", MemberOf: []string{"staff"}, Settings: Parameters{"application_name": "alice"}},
+		"staff":   {Name: "staff", MemberOf: []string{"readers"}},
+		"readers": {Name: "readers"},
+		"bob":     {Name: "bob"},
+	}
+
+	lookup := func(ctx context.Context, name string) (*Role, error) {
+		return roles[name], nil
+	}
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			var err error
+			query := query.Query
+			switch {
+			case strings.HasPrefix(query, "SET ROLE "):
+				err = SetRole(ctx, strings.TrimPrefix(query, "SET ROLE "))
+			case query == "RESET ROLE":
+				err = SetRole(ctx, "")
+			case strings.HasPrefix(query, "SET SESSION AUTHORIZATION "):
+				err = SetSessionAuthorization(ctx, strings.TrimPrefix(query, "SET SESSION AUTHORIZATION "))
+			case query == "RESET SESSION AUTHORIZATION":
+				err = SetSessionAuthorization(ctx, "")
+			}
+
+			if err != nil {
+				return err
+			}
+
+			return writer.Complete("SET")
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), RoleLookup(lookup))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connect := func(t *testing.T, user string) *pgx.Conn {
+		ctx := context.Background()
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://%s@%s:%d?sslmode=disable", user, address.IP, address.Port))
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close(ctx) }) //nolint:errcheck
+		return conn
+	}
+
+	exec := func(conn *pgx.Conn, query string) error {
+		_, err := conn.Exec(context.Background(), query, pgx.QueryExecModeSimpleProtocol)
+		return err
+	}
+
+	t.Run("superuser", func(t *testing.T) {
+		conn := connect(t, "admin")
+		assert.Equal(t, "on", conn.PgConn().ParameterStatus("is_superuser"))
+		assert.Equal(t, "admin", conn.PgConn().ParameterStatus("session_authorization"))
+
+		require.NoError(t, exec(conn, "SET SESSION AUTHORIZATION bob"))
+		assert.Equal(t, "off", conn.PgConn().ParameterStatus("is_superuser"))
+		assert.Equal(t, "bob", conn.PgConn().ParameterStatus("session_authorization"))
+
+		err := exec(conn, "SET ROLE readers")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "42501")
+
+		require.NoError(t, exec(conn, "RESET SESSION AUTHORIZATION"))
+		assert.Equal(t, "on", conn.PgConn().ParameterStatus("is_superuser"))
+		assert.Equal(t, "admin", conn.PgConn().ParameterStatus("session_authorization"))
+	})
+
+	t.Run("member", func(t *testing.T) {
+		conn := connect(t, "alice")
+		assert.Equal(t, "off", conn.PgConn().ParameterStatus("is_superuser"))
+		assert.Equal(t, "alice", conn.PgConn().ParameterStatus("session_authorization"))
+		assert.Equal(t, "alice", conn.PgConn().ParameterStatus("application_name"))
+
+		require.NoError(t, exec(conn, "SET ROLE readers"))
+		require.NoError(t, exec(conn, "RESET ROLE"))
+
+		err := exec(conn, "SET ROLE admin")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `permission denied to set role "admin"`)
+
+		err = exec(conn, "SET ROLE unknown")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "22023")
+
+		err = exec(conn, "SET SESSION AUTHORIZATION bob")
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "permission denied to set session authorization")
+	})
+
+	t.Run("unknown role", func(t *testing.T) {
+		ctx := context.Background()
+		_, err := pgx.Connect(ctx, fmt.Sprintf("postgres://unknown@%s:%d?sslmode=disable", address.IP, address.Port))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), `role "unknown" does not exist`)
+	})
+}
+
+func TestWithRole(t *testing.T) {
+	t.Parallel()
+
+	validate := func(ctx context.Context, database, username, password string) (context.Context, bool, error) {
+		return WithRole(ctx, &Role{Name: "mapped", Superuser: true}), true, nil
+	}
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			assert.True(t, IsSuperUser(ctx))
+			assert.Equal(t, "mapped", AuthenticatedUsername(ctx))
+			assert.Equal(t, "mapped", SessionRole(ctx).Name)
+			assert.Equal(t, "mapped", CurrentRole(ctx).Name)
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), SessionAuthStrategy(ClearTextPassword(validate)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	ctx := context.Background()
+	conn, err := pgx.Connect(ctx, fmt.Sprintf("postgres://admin:secret@%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(ctx) //nolint:errcheck
+
+	assert.Equal(t, "on", conn.PgConn().ParameterStatus("is_superuser"))
+	assert.Equal(t, "mapped", conn.PgConn().ParameterStatus("session_authorization"))
+
+	_, err = conn.Exec(ctx, "SELECT 1", pgx.QueryExecModeSimpleProtocol)
+	require.NoError(t, err)
+}
@@ -156,22 +156,9 @@ func (column Column) Write(ctx context.Context, writer *buffer.Writer, format Fo
 		return errors.New("postgres connection info has not been defined inside the given context")
 	}
 
-	bb := make([]byte, 0)
-	bb, err = tm.Encode(uint32(column.Oid), int16(format), src, bb)
-	if err != nil {
-		return err
-	}
-
-	// NOTE: The length of the column value, in bytes (this count does
-	// not include itself). Can be zero. As a special case, -1 indicates a NULL
-	// column value. No value bytes follow in the NULL case.
-	length := int32(len(bb))
-	if src == nil {
-		length = -1
-	}
-
-	writer.AddInt32(length)
-	writer.AddBytes(bb)
-
-	return nil
+	// NOTE: the value is encoded directly into the writer frame. The length of
+	// the column value, in bytes (this count does not include itself). Can be
+	// zero. As a special case, -1 indicates a NULL column value. No value bytes
+	// follow in the NULL case.
+	return writer.AddValue(tm, column.Oid, int16(format), src)
 }
@@ -0,0 +1,116 @@
+package wire
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log/slog"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestColumnsWrite(t *testing.T) {
+	t.Parallel()
+
+	columns := Columns{
+		{Name: "id", Oid: pgtype.Int4OID},
+		{Name: "name", Oid: pgtype.TextOID},
+		{Name: "email", Oid: pgtype.TextOID},
+		{Name: "nickname", Oid: pgtype.TextOID},
+	}
+
+	var nickname *string
+	ctx := setTypeInfo(context.Background(), pgtype.NewMap())
+
+	output := &bytes.Buffer{}
+	writer := buffer.NewWriter(slogt.New(t), output)
+	err := columns.Write(ctx, []FormatCode{BinaryFormat, TextFormat}, writer, []any{int32(42), "John Doe", nil, nickname})
+	require.NoError(t, err)
+
+	expected := []byte{byte(types.ServerDataRow), 0, 0, 0, 34, 0, 4}
+	expected = append(expected, 0, 0, 0, 4, 0, 0, 0, 42)
+	expected = append(expected, 0, 0, 0, 8)
+	expected = append(expected, "John Doe"...)
+	expected = append(expected, 0xff, 0xff, 0xff, 0xff)
+	expected = append(expected, 0xff, 0xff, 0xff, 0xff)
+	assert.Equal(t, expected, output.Bytes())
+
+	err = columns.Write(ctx, []FormatCode{BinaryFormat}, writer, []any{"invalid", "John Doe", nil, nil})
+	require.Error(t, err)
+
+	err = columns.Write(ctx, nil, writer, []any{int32(42)})
+	require.Error(t, err)
+}
+
+// BenchmarkColumnsWrite benchmarks the allocations made while encoding data
+// rows of common types using the text and binary formats. Values are encoded
+// directly into the writer frame, remaining allocations are made by the pgtype
+// codecs (ex: text encoded int8 and timestamptz values).
+func BenchmarkColumnsWrite(b *testing.B) {
+	created := time.Date(2024, time.January, 1, 12, 0, 0, 0, time.UTC)
+
+	type value struct {
+		oid   uint32
+		value any
+	}
+
+	values := map[string]value{
+		"int4":        {pgtype.Int4OID, int32(42)},
+		"int8":        {pgtype.Int8OID, int64(1 << 40)},
+		"float8":      {pgtype.Float8OID, 3.14},
+		"bool":        {pgtype.BoolOID, true},
+		"text":        {pgtype.TextOID, "John Doe"},
+		"bytea":       {pgtype.ByteaOID, []byte("John Doe")},
+		"timestamptz": {pgtype.TimestamptzOID, created},
+		"null":        {pgtype.TextOID, nil},
+	}
+
+	formats := map[string]FormatCode{
+		"text":   TextFormat,
+		"binary": BinaryFormat,
+	}
+
+	ctx := setTypeInfo(context.Background(), pgtype.NewMap())
+	writer := buffer.NewWriter(slog.New(slog.DiscardHandler), io.Discard)
+
+	for format, code := range formats {
+		for name, value := range values {
+			columns := Columns{{Name: name, Oid: value.oid}}
+			row := []any{value.value}
+
+			b.Run(format+"/"+name, func(b *testing.B) {
+				b.ReportAllocs()
+				for b.Loop() {
+					err := columns.Write(ctx, []FormatCode{code}, writer, row)
+					if err != nil {
+						b.Fatal(err)
+					}
+				}
+			})
+		}
+
+		columns := make(Columns, 0, len(values))
+		row := make([]any, 0, len(values))
+		for name, value := range values {
+			columns = append(columns, Column{Name: name, Oid: value.oid})
+			row = append(row, value.value)
+		}
+
+		b.Run(format+"/row", func(b *testing.B) {
+			b.ReportAllocs()
+			for b.Loop() {
+				err := columns.Write(ctx, []FormatCode{code}, writer, row)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
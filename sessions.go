@@ -0,0 +1,559 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"iter"
+	"maps"
+	"net"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// SessionState represents the current state of a session, equivalent to the
+// state column of pg_stat_activity.
+type SessionState string
+
+const (
+	// SessionActive indicates that the session is executing a query.
+	SessionActive SessionState = "active"
+	// SessionIdle indicates that the session is waiting for a new command.
+	SessionIdle SessionState = "idle"
+	// SessionIdleInTransaction indicates that the session is inside a
+	// transaction block but is not executing a query.
+	SessionIdleInTransaction SessionState = "idle in transaction"
+	// SessionIdleInTransactionAborted indicates that the session is inside a
+	// failed transaction block.
+	SessionIdleInTransactionAborted SessionState = "idle in transaction (aborted)"
+)
+
+// SessionInfo represents a snapshot of a session served by the server,
+// modelled after pg_stat_activity.
+type SessionInfo struct {
+	// PID is the backend process ID of the session. The process ID equals the
+	// process ID returned by the configured [BackendKeyDataFunc].
+	PID             int32
+	User            string
+	Database        string
+	ApplicationName string
+	ClientAddr      net.Addr
+	// BackendStart is the time at which the client connected.
+	BackendStart time.Time
+	State        SessionState
+	// StateChange is the time at which the state was last changed.
+	StateChange time.Time
+	// Query contains the most recent query received from the client. The
+	// query is kept once the session has returned to an idle state.
+	Query string
+	// QueryStart is the time at which the most recent query was received.
+	QueryStart time.Time
+}
+
+// sessionActivity tracks the activity of a single session.
+type sessionActivity struct {
+	mu   sync.Mutex
+	info SessionInfo
+}
+
+// newSessionActivity constructs a new activity tracker for the client connected
+// to the given context.
+func newSessionActivity(ctx context.Context, pid int32, started time.Time) *sessionActivity {
+	params := ClientParameters(ctx)
+	user := params[ParamUsername]
+	if role := AuthenticatedRole(ctx); role != nil {
+		user = role.Name
+	}
+
+	return &sessionActivity{
+		info: SessionInfo{
+			PID:             pid,
+			User:            user,
+			Database:        params[ParamDatabase],
+			ApplicationName: params[ParamApplicationName],
+			ClientAddr:      RemoteAddress(ctx),
+			BackendStart:    started,
+			State:           SessionIdle,
+			StateChange:     time.Now(),
+		},
+	}
+}
+
+// query marks the session as active executing the given query.
+func (activity *sessionActivity) query(query string) {
+	if activity == nil {
+		return
+	}
+
+	activity.mu.Lock()
+	defer activity.mu.Unlock()
+
+	now := time.Now()
+	activity.info.Query = query
+	activity.info.QueryStart = now
+	activity.setState(SessionActive, now)
+}
+
+// active marks the session as active executing the most recent query.
+func (activity *sessionActivity) active() {
+	if activity == nil {
+		return
+	}
+
+	activity.mu.Lock()
+	defer activity.mu.Unlock()
+
+	activity.setState(SessionActive, time.Now())
+}
+
+// ready marks the session as idle using the given transaction status.
+func (activity *sessionActivity) ready(status types.ServerStatus) {
+	if activity == nil {
+		return
+	}
+
+	state := SessionIdle
+	switch status {
+	case types.ServerTransactionBlock:
+		state = SessionIdleInTransaction
+	case types.ServerTransactionFailed:
+		state = SessionIdleInTransactionAborted
+	}
+
+	activity.mu.Lock()
+	defer activity.mu.Unlock()
+
+	activity.setState(state, time.Now())
+}
+
+// setState updates the state of the session. The state change time is only
+// updated when the state has changed.
+func (activity *sessionActivity) setState(state SessionState, now time.Time) {
+	if activity.info.State == state {
+		return
+	}
+
+	activity.info.State = state
+	activity.info.StateChange = now
+}
+
+// snapshot returns a copy of the current session information.
+func (activity *sessionActivity) snapshot() SessionInfo {
+	activity.mu.Lock()
+	defer activity.mu.Unlock()
+	return activity.info
+}
+
+// sessionRegistry tracks the sessions served by the server.
+type sessionRegistry struct {
+	mu       sync.RWMutex
+	sessions map[int32]*Session
+	pid      atomic.Int32
+	// wg tracks the registered sessions until they have been deregistered.
+	wg sync.WaitGroup
+}
+
+// newSessionRegistry constructs a new empty session registry.
+func newSessionRegistry() *sessionRegistry {
+	return &sessionRegistry{
+		sessions: make(map[int32]*Session),
+	}
+}
+
+// nextPID returns a new unique process ID.
+func (registry *sessionRegistry) nextPID() int32 {
+	for {
+		pid := registry.pid.Add(1)
+		if pid > 0 {
+			return pid
+		}
+
+		// NOTE: process ID's are positive, wrap around once overflown.
+		registry.pid.CompareAndSwap(pid, 0)
+	}
+}
+
+// register adds the given session to the registry. The returned function
+// removes the session from the registry.
+func (registry *sessionRegistry) register(session *Session) (deregister func()) {
+	pid := session.activity.info.PID
+
+	registry.mu.Lock()
+	registry.sessions[pid] = session
+	registry.mu.Unlock()
+	registry.wg.Add(1)
+
+	return func() {
+		defer registry.wg.Done()
+
+		registry.mu.Lock()
+		defer registry.mu.Unlock()
+
+		// NOTE: the process ID could have been claimed by another session
+		// when process ID's are provided by the BackendKeyDataFunc.
+		if registry.sessions[pid] == session {
+			delete(registry.sessions, pid)
+		}
+	}
+}
+
+// get returns the session with the given process ID.
+func (registry *sessionRegistry) get(pid int32) (*Session, bool) {
+	registry.mu.RLock()
+	defer registry.mu.RUnlock()
+
+	session, has := registry.sessions[pid]
+	return session, has
+}
+
+// all returns all registered sessions ordered by process ID.
+func (registry *sessionRegistry) all() []*Session {
+	registry.mu.RLock()
+	pids := slices.Sorted(maps.Keys(registry.sessions))
+	sessions := make([]*Session, 0, len(pids))
+	for _, pid := range pids {
+		sessions = append(sessions, registry.sessions[pid])
+	}
+	registry.mu.RUnlock()
+
+	return sessions
+}
+
+// Sessions returns a snapshot of all sessions currently served by the server
+// ordered by process ID.
+func (srv *Server) Sessions() []SessionInfo {
+	sessions := srv.sessions.all()
+	infos := make([]SessionInfo, len(sessions))
+	for index, session := range sessions {
+		infos[index] = session.activity.snapshot()
+	}
+
+	return infos
+}
+
+// AllSessions returns an iterator over all sessions currently served by the
+// server ordered by process ID. A snapshot of each session is taken once the
+// session is reached by the iterator.
+func (srv *Server) AllSessions() iter.Seq[SessionInfo] {
+	return func(yield func(SessionInfo) bool) {
+		for _, session := range srv.sessions.all() {
+			if !yield(session.activity.snapshot()) {
+				return
+			}
+		}
+	}
+}
+
+var (
+	errQueryCanceled = errors.New("canceling statement due to user request")
+	errAdminShutdown = errors.New("terminating connection due to administrator command")
+)
+
+// NewErrQueryCanceled is returned whenever the statement executed by the
+// session has been canceled.
+func NewErrQueryCanceled() error {
+	return psqlerr.WithSeverity(psqlerr.WithCode(errQueryCanceled, codes.QueryCanceled), psqlerr.LevelError)
+}
+
+// NewErrAdminShutdown is returned whenever the session has been terminated by
+// an administrator.
+func NewErrAdminShutdown() error {
+	return psqlerr.WithSeverity(psqlerr.WithCode(errAdminShutdown, codes.AdminShutdown), psqlerr.LevelFatal)
+}
+
+// sessionControl allows the session to be interrupted from outside of the
+// session. Statements are executed using the context of the current query
+// cycle, a query cycle lasts until the session is ready for a new query.
+type sessionControl struct {
+	mu         sync.Mutex
+	conn       net.Conn
+	ctx        context.Context
+	cancel     context.CancelCauseFunc
+	terminated error
+	// secretKey is the secret key send to the client inside the backend key
+	// data, used to authorize cancel requests targeting the session.
+	secretKey []byte
+	// status is the transaction status most recently send to the client.
+	status types.ServerStatus
+	// timeout is the error reported once the read deadline set while
+	// waiting for a new command expires.
+	timeout error
+	// timer cancels the current query cycle once the statement timeout has
+	// expired.
+	timer *time.Timer
+	// timeouts contains the timeout parameters overridden by the session
+	// indexed by parameter name.
+	timeouts map[string]time.Duration
+}
+
+// newSessionControl constructs a new session control for the given connection
+// and secret key.
+func newSessionControl(conn net.Conn, secretKey []byte) *sessionControl {
+	return &sessionControl{conn: conn, secretKey: secretKey, status: types.ServerIdle}
+}
+
+// begin returns the context of the current query cycle. A new query cycle is
+// started if none is active.
+func (control *sessionControl) begin(ctx context.Context) context.Context {
+	if control == nil {
+		return ctx
+	}
+
+	control.mu.Lock()
+	defer control.mu.Unlock()
+
+	if control.ctx == nil {
+		control.ctx, control.cancel = context.WithCancelCause(ctx)
+		if control.terminated != nil {
+			control.cancel(control.terminated)
+		}
+	}
+
+	return control.ctx
+}
+
+// end ends the current query cycle.
+func (control *sessionControl) end() {
+	if control == nil {
+		return
+	}
+
+	control.mu.Lock()
+	defer control.mu.Unlock()
+
+	if control.cancel != nil {
+		control.cancel(nil)
+	}
+
+	if control.timer != nil {
+		control.timer.Stop()
+	}
+
+	control.ctx = nil
+	control.cancel = nil
+	control.timer = nil
+}
+
+// startTimer cancels the current query cycle using the given cause once the
+// given timeout expires. The timer is not restarted if already running.
+func (control *sessionControl) startTimer(timeout time.Duration, cause error) {
+	if control == nil || timeout <= 0 {
+		return
+	}
+
+	control.mu.Lock()
+	defer control.mu.Unlock()
+
+	if control.cancel == nil || control.timer != nil {
+		return
+	}
+
+	// NOTE: the cancel function of the current query cycle is captured,
+	// ensuring that a expired timer never cancels a succeeding query cycle.
+	cancel := control.cancel
+	control.timer = time.AfterFunc(timeout, func() {
+		cancel(cause)
+	})
+}
+
+// stopTimer stops the timer started for the current query cycle.
+func (control *sessionControl) stopTimer() {
+	if control == nil {
+		return
+	}
+
+	control.mu.Lock()
+	defer control.mu.Unlock()
+
+	if control.timer != nil {
+		control.timer.Stop()
+		control.timer = nil
+	}
+}
+
+// ready ends the current query cycle, the session is ready for a new query
+// using the given transaction status.
+func (control *sessionControl) ready(status types.ServerStatus) {
+	if control == nil {
+		return
+	}
+
+	control.end()
+
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	control.status = status
+}
+
+// idle returns the transaction status of the session and whether the session
+// is waiting for a new query.
+func (control *sessionControl) idle() (types.ServerStatus, bool) {
+	if control == nil {
+		return types.ServerIdle, false
+	}
+
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	return control.status, control.ctx == nil
+}
+
+// wait sets a read deadline expiring after the given timeout while waiting
+// for a new command. The given error is reported once the deadline expires.
+// The read deadline is left untouched once the session has been terminated.
+func (control *sessionControl) wait(timeout time.Duration, err error) error {
+	control.mu.Lock()
+	defer control.mu.Unlock()
+
+	if control.terminated != nil {
+		return nil
+	}
+
+	control.timeout = err
+	return control.conn.SetReadDeadline(time.Now().Add(timeout))
+}
+
+// resume clears the read deadline set while waiting for a new command. The
+// session is terminated using the error given to wait if the deadline has
+// expired.
+func (control *sessionControl) resume(err error) error {
+	if control == nil {
+		return nil
+	}
+
+	control.mu.Lock()
+	defer control.mu.Unlock()
+
+	if control.timeout == nil || control.terminated != nil {
+		return nil
+	}
+
+	if errors.Is(err, os.ErrDeadlineExceeded) {
+		control.terminated = control.timeout
+		return nil
+	}
+
+	control.timeout = nil
+	return control.conn.SetReadDeadline(time.Time{})
+}
+
+// interrupt cancels the current query cycle using the given cause. False is
+// returned if no query cycle is active.
+func (control *sessionControl) interrupt(cause error) bool {
+	control.mu.Lock()
+	defer control.mu.Unlock()
+
+	if control.cancel == nil {
+		return false
+	}
+
+	control.cancel(cause)
+	return true
+}
+
+// terminate terminates the session. The current query cycle is canceled and
+// any pending read is interrupted, the session writes the termination error
+// to the client before closing the connection.
+func (control *sessionControl) terminate() {
+	control.mu.Lock()
+	defer control.mu.Unlock()
+
+	if control.terminated != nil {
+		return
+	}
+
+	control.terminated = NewErrAdminShutdown()
+	if control.cancel != nil {
+		control.cancel(control.terminated)
+	}
+
+	control.conn.SetReadDeadline(time.Now()) //nolint:errcheck
+}
+
+// close terminates the session by closing the underlying connection without
+// informing the client. The current query cycle is canceled.
+func (control *sessionControl) close() {
+	control.mu.Lock()
+	defer control.mu.Unlock()
+
+	if control.terminated == nil {
+		control.terminated = NewErrAdminShutdown()
+	}
+
+	if control.cancel != nil {
+		control.cancel(control.terminated)
+	}
+
+	control.conn.Close() //nolint:errcheck
+}
+
+// err returns the termination error if the session has been terminated.
+func (control *sessionControl) err() error {
+	if control == nil {
+		return nil
+	}
+
+	control.mu.Lock()
+	defer control.mu.Unlock()
+	return control.terminated
+}
+
+// writeTerminated writes the given termination error to the client. A short
+// write deadline is set to ensure that slow clients are not able to block the
+// connection from being closed.
+func (srv *Session) writeTerminated(writer *buffer.Writer, err error) error {
+	srv.logger.Debug("session terminated", "err", err)
+
+	werr := srv.control.conn.SetWriteDeadline(time.Now().Add(timeoutErrorWriteTimeout))
+	if werr != nil {
+		return err
+	}
+
+	WriteUnterminatedError(writer, err) //nolint:errcheck
+	writer.Flush()                      //nolint:errcheck
+	return err
+}
+
+// ProcessID returns the backend process ID of the session.
+func (srv *Session) ProcessID() int32 {
+	if srv.activity == nil {
+		return 0
+	}
+
+	return srv.activity.info.PID
+}
+
+// TerminateSession terminates the session with the given process ID, similar
+// to pg_terminate_backend. The client is informed using a FATAL admin_shutdown
+// error before the connection is closed. False is returned if no session with
+// the given process ID exists.
+func (srv *Server) TerminateSession(pid int32) bool {
+	session, has := srv.sessions.get(pid)
+	if !has {
+		return false
+	}
+
+	session.control.terminate()
+	return true
+}
+
+// CancelSession cancels the statement currently executed by the session with
+// the given process ID, similar to pg_cancel_backend. The context of the
+// statement is canceled and a query_canceled error is reported to the client
+// once the statement returns the context error. False is returned if no
+// session with the given process ID exists or if the session is not executing
+// a statement.
+func (srv *Server) CancelSession(pid int32) bool {
+	session, has := srv.sessions.get(pid)
+	if !has {
+		return false
+	}
+
+	return session.cancel()
+}
@@ -0,0 +1,259 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestSessionRegistry(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	var transaction atomic.Bool
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			switch query.Query {
+			case "SELECT pg_sleep(1)":
+				started <- struct{}{}
+				<-release
+			case "BEGIN":
+				transaction.Store(true)
+			}
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	status := func(ctx context.Context) types.ServerStatus {
+		if transaction.Load() {
+			return types.ServerTransactionBlock
+		}
+		return types.ServerIdle
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), TxStatus(status))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	assert.Empty(t, server.Sessions())
+
+	before := time.Now()
+	connstr := fmt.Sprintf("postgres://alice@%s:%d/app?sslmode=disable&application_name=reports", address.IP, address.Port)
+	conn, err := pgx.Connect(context.Background(), connstr)
+	require.NoError(t, err)
+
+	sessions := server.Sessions()
+	require.Len(t, sessions, 1)
+
+	session := sessions[0]
+	assert.Positive(t, session.PID)
+	assert.Equal(t, "alice", session.User)
+	assert.Equal(t, "app", session.Database)
+	assert.Equal(t, "reports", session.ApplicationName)
+	assert.Equal(t, conn.PgConn().Conn().LocalAddr().String(), session.ClientAddr.String())
+	assert.False(t, session.BackendStart.Before(before))
+	assert.Equal(t, SessionIdle, session.State)
+	assert.Empty(t, session.Query)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Exec(context.Background(), "SELECT pg_sleep(1)")
+		done <- err
+	}()
+
+	<-started
+	session = server.Sessions()[0]
+	assert.Equal(t, SessionActive, session.State)
+	assert.Equal(t, "SELECT pg_sleep(1)", session.Query)
+	assert.False(t, session.QueryStart.Before(session.BackendStart))
+
+	close(release)
+	require.NoError(t, <-done)
+
+	session = server.Sessions()[0]
+	assert.Equal(t, SessionIdle, session.State)
+	assert.Equal(t, "SELECT pg_sleep(1)", session.Query)
+
+	_, err = conn.Exec(context.Background(), "BEGIN")
+	require.NoError(t, err)
+	assert.Equal(t, SessionIdleInTransaction, server.Sessions()[0].State)
+
+	second, err := pgx.Connect(context.Background(), connstr)
+	require.NoError(t, err)
+
+	pids := make([]int32, 0, 2)
+	for session := range server.AllSessions() {
+		pids = append(pids, session.PID)
+	}
+
+	require.Len(t, pids, 2)
+	assert.True(t, slices.IsSorted(pids))
+
+	require.NoError(t, conn.Close(context.Background()))
+	require.NoError(t, second.Close(context.Background()))
+	require.Eventually(t, func() bool {
+		return len(server.Sessions()) == 0
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestSessionRegistryBackendKeyData(t *testing.T) {
+	t.Parallel()
+
+	keys := func(ctx context.Context) (int32, []byte) {
+		return 4242, []byte("key!")
+	}
+
+	server, err := NewServer(nil, Logger(slogt.New(t)), BackendKeyData(keys))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	conn, err := pgx.Connect(context.Background(), fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(context.Background()) //nolint:errcheck
+
+	sessions := server.Sessions()
+	require.Len(t, sessions, 1)
+	assert.Equal(t, int32(4242), sessions[0].PID)
+	assert.Equal(t, uint32(4242), conn.PgConn().PID())
+}
+
+func TestSessionRegistryNextPID(t *testing.T) {
+	t.Parallel()
+
+	registry := newSessionRegistry()
+	assert.Equal(t, int32(1), registry.nextPID())
+
+	registry.pid.Store(1<<31 - 1)
+	assert.Equal(t, int32(1), registry.nextPID())
+}
+
+func TestTerminateSession(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{}, 1)
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			if query.Query == "SELECT pg_sleep(60)" {
+				started <- struct{}{}
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	var closed atomic.Int32
+	server, err := NewServer(handler, Logger(slogt.New(t)), CloseConn(func(ctx context.Context) error {
+		closed.Add(1)
+		return nil
+	}))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	connstr := fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port)
+
+	t.Run("idle", func(t *testing.T) {
+		conn, err := pgx.Connect(context.Background(), connstr)
+		require.NoError(t, err)
+		defer conn.Close(context.Background()) //nolint:errcheck
+
+		pid := server.Sessions()[0].PID
+		require.True(t, server.TerminateSession(pid))
+
+		require.Eventually(t, func() bool {
+			_, has := server.sessions.get(pid)
+			return !has
+		}, time.Second, 10*time.Millisecond)
+
+		_, err = conn.Exec(context.Background(), "SELECT 1")
+		require.Error(t, err)
+	})
+
+	t.Run("active", func(t *testing.T) {
+		conn, err := pgx.Connect(context.Background(), connstr)
+		require.NoError(t, err)
+		defer conn.Close(context.Background()) //nolint:errcheck
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := conn.Exec(context.Background(), "SELECT pg_sleep(60)")
+			done <- err
+		}()
+
+		<-started
+		require.True(t, server.TerminateSession(server.Sessions()[0].PID))
+
+		err = <-done
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, string(codes.AdminShutdown), pgErr.Code)
+		assert.Equal(t, "FATAL", pgErr.Severity)
+		assert.Equal(t, "terminating connection due to administrator command", pgErr.Message)
+	})
+
+	assert.False(t, server.TerminateSession(-1))
+	require.Eventually(t, func() bool {
+		return closed.Load() == 2
+	}, time.Second, 10*time.Millisecond)
+}
+
+func TestCancelSession(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{}, 1)
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			if query.Query == "SELECT pg_sleep(60)" {
+				started <- struct{}{}
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	conn, err := pgx.Connect(context.Background(), fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(context.Background()) //nolint:errcheck
+
+	pid := server.Sessions()[0].PID
+	assert.False(t, server.CancelSession(pid))
+	assert.False(t, server.CancelSession(-1))
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Exec(context.Background(), "SELECT pg_sleep(60)")
+		done <- err
+	}()
+
+	<-started
+	require.True(t, server.CancelSession(pid))
+
+	err = <-done
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, string(codes.QueryCanceled), pgErr.Code)
+	assert.Equal(t, "ERROR", pgErr.Severity)
+
+	// NOTE: the session remains usable once the statement has been canceled
+	_, err = conn.Exec(context.Background(), "SELECT 1")
+	require.NoError(t, err)
+	assert.Equal(t, SessionIdle, server.Sessions()[0].State)
+}
@@ -0,0 +1,93 @@
+package wire
+
+import (
+	"errors"
+	"time"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	psqlerr "github.com/jeroenrinzema/psql-wire/errors"
+)
+
+// ShutdownMode represents the way sessions are treated once the server is
+// shutting down, mirroring the PostgreSQL shutdown modes.
+type ShutdownMode int
+
+const (
+	// ShutdownSmart waits for all sessions to be closed by their clients.
+	// Sessions continue to be served while the server is shutting down and
+	// running statements are allowed to complete. Sessions which have not
+	// been closed once the shutdown times out are terminated as during a fast
+	// shutdown. This is the default shutdown mode.
+	ShutdownSmart ShutdownMode = iota
+	// ShutdownFast terminates all sessions. Running statements are canceled
+	// and clients are informed using a FATAL admin_shutdown error before the
+	// connection is closed.
+	ShutdownFast
+	// ShutdownImmediate closes the connections of all sessions without
+	// informing the clients.
+	ShutdownImmediate
+)
+
+// String returns the PostgreSQL name of the shutdown mode.
+func (mode ShutdownMode) String() string {
+	switch mode {
+	case ShutdownSmart:
+		return "smart"
+	case ShutdownFast:
+		return "fast"
+	case ShutdownImmediate:
+		return "immediate"
+	default:
+		return "unknown"
+	}
+}
+
+// shutdownEscalationTimeout is the maximum duration spent waiting for sessions
+// terminated after a shutdown has timed out before their connections are
+// closed.
+const shutdownEscalationTimeout = 25 * time.Millisecond
+
+// NewErrShuttingDown is returned whenever a client attempts to start a new
+// session while the server is shutting down.
+func NewErrShuttingDown() error {
+	err := errors.New("the database system is shutting down")
+	return psqlerr.WithSeverity(psqlerr.WithCode(err, codes.CannotConnectNow), psqlerr.LevelFatal)
+}
+
+// shutdownSessions shuts down the sessions currently served by the server
+// using the configured shutdown mode. Sessions are not touched during a smart
+// shutdown.
+func (srv *Server) shutdownSessions() {
+	for _, session := range srv.sessions.all() {
+		switch srv.ShutdownMode {
+		case ShutdownFast:
+			session.control.terminate()
+		case ShutdownImmediate:
+			session.control.close()
+		}
+	}
+}
+
+// escalateShutdown shuts down the remaining sessions once a shutdown has timed
+// out. Sessions are terminated as during a fast shutdown, the connections of
+// sessions which have not been closed before the escalation timeout expires
+// are closed as during an immediate shutdown.
+func (srv *Server) escalateShutdown(done <-chan struct{}) {
+	srv.draining.Store(false)
+	for _, session := range srv.sessions.all() {
+		session.control.terminate()
+	}
+
+	timer := time.NewTimer(shutdownEscalationTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-done:
+		return
+	case <-timer.C:
+	}
+
+	for _, session := range srv.sessions.all() {
+		session.control.close()
+	}
+}
@@ -1,5 +1,15 @@
 package wire
 
+import (
+	"crypto"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"slices"
+)
+
 // sslIdentifier represents the bytes identifying whether the given connection
 // supports SSL.
 type sslIdentifier []byte
@@ -8,3 +18,146 @@ var (
 	sslSupported   sslIdentifier = []byte{'S'}
 	sslUnsupported sslIdentifier = []byte{'N'}
 )
+
+// alpnProtocol is the ALPN protocol name used by PostgreSQL clients.
+const alpnProtocol = "postgresql"
+
+// tlsHandshakeRecord is the first byte of a TLS ClientHello record.
+const tlsHandshakeRecord = 0x16
+
+// bufferedConn represents a connection of which the first bytes have already
+// been consumed by the given reader.
+type bufferedConn struct {
+	net.Conn
+	reader io.Reader
+}
+
+func (conn *bufferedConn) Read(b []byte) (int, error) {
+	return conn.reader.Read(b)
+}
+
+// secureConn represents a connection which has been upgraded to TLS. The
+// certificate presented to the client during the TLS handshake is tracked in
+// order to compute channel binding data.
+type secureConn struct {
+	*tls.Conn
+	certificate *tls.Certificate
+}
+
+// hasCertificates checks whether the given TLS config is able to present a
+// certificate to clients. Certificates could be provided directly or through
+// the GetCertificate and GetConfigForClient callbacks.
+func hasCertificates(config *tls.Config) bool {
+	if config == nil {
+		return false
+	}
+
+	return len(config.Certificates) > 0 || config.GetCertificate != nil || config.GetConfigForClient != nil
+}
+
+// newSecureConn constructs a new TLS server connection for the given
+// connection using the given TLS config. The certificate selected during the
+// TLS handshake is recorded inside the returned connection.
+func newSecureConn(conn net.Conn, config *tls.Config) *secureConn {
+	secure := &secureConn{}
+	secure.Conn = tls.Server(conn, secure.prepare(config))
+	return secure
+}
+
+// prepare clones the given TLS config and ensures that the certificate
+// selected during the TLS handshake is recorded. The PostgreSQL ALPN protocol
+// is prepended to the configured protocols whenever missing. Configs returned by
+// GetConfigForClient are prepared as well.
+func (secure *secureConn) prepare(config *tls.Config) *tls.Config {
+	config = config.Clone()
+	certificates := config.Certificates
+	getCertificate := config.GetCertificate
+	getConfigForClient := config.GetConfigForClient
+
+	// NOTE: the certificates are removed from the config to ensure that the
+	// TLS server always consults GetCertificate during the handshake.
+	config.Certificates = nil
+	if !slices.Contains(config.NextProtos, alpnProtocol) {
+		config.NextProtos = append([]string{alpnProtocol}, config.NextProtos...)
+	}
+
+	config.GetCertificate = func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+		certificate, err := selectCertificate(hello, getCertificate, certificates)
+		secure.certificate = certificate
+		return certificate, err
+	}
+
+	if getConfigForClient != nil {
+		config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			override, err := getConfigForClient(hello)
+			if override == nil || err != nil {
+				return override, err
+			}
+
+			return secure.prepare(override), nil
+		}
+	}
+
+	return config
+}
+
+// selectCertificate selects the certificate presented to the client following
+// the same rules as the standard library TLS server.
+func selectCertificate(hello *tls.ClientHelloInfo, getCertificate func(*tls.ClientHelloInfo) (*tls.Certificate, error), certificates []tls.Certificate) (*tls.Certificate, error) {
+	if getCertificate != nil {
+		certificate, err := getCertificate(hello)
+		if certificate != nil || err != nil {
+			return certificate, err
+		}
+	}
+
+	if len(certificates) == 0 {
+		return nil, errors.New("no certificates configured")
+	}
+
+	if len(certificates) > 1 {
+		for index := range certificates {
+			if hello.SupportsCertificate(&certificates[index]) == nil {
+				return &certificates[index], nil
+			}
+		}
+	}
+
+	return &certificates[0], nil
+}
+
+// tlsServerEndPoint computes the tls-server-end-point channel binding data
+// (RFC 5929) of the given certificate. The certificate is hashed using the
+// hash function of its signature algorithm, MD5 and SHA-1 are upgraded to
+// SHA-256.
+func tlsServerEndPoint(certificate *tls.Certificate) ([]byte, error) {
+	if certificate == nil || len(certificate.Certificate) == 0 {
+		return nil, errors.New("no server certificate available")
+	}
+
+	leaf := certificate.Leaf
+	if leaf == nil {
+		var err error
+		leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	var hash crypto.Hash
+	switch leaf.SignatureAlgorithm {
+	case x509.MD5WithRSA, x509.SHA1WithRSA, x509.ECDSAWithSHA1, x509.DSAWithSHA1,
+		x509.SHA256WithRSA, x509.SHA256WithRSAPSS, x509.ECDSAWithSHA256, x509.DSAWithSHA256:
+		hash = crypto.SHA256
+	case x509.SHA384WithRSA, x509.SHA384WithRSAPSS, x509.ECDSAWithSHA384:
+		hash = crypto.SHA384
+	case x509.SHA512WithRSA, x509.SHA512WithRSAPSS, x509.ECDSAWithSHA512:
+		hash = crypto.SHA512
+	default:
+		return nil, errors.New("could not determine server certificate signature algorithm")
+	}
+
+	h := hash.New()
+	h.Write(leaf.Raw)
+	return h.Sum(nil), nil
+}
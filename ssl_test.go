@@ -0,0 +1,181 @@
+package wire
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"slices"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jeroenrinzema/psql-wire/pkg/mock"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDirectTLS(t *testing.T) {
+	t.Parallel()
+
+	cert, err := generateTestCert()
+	require.NoError(t, err)
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), TLSConfig(&tls.Config{Certificates: []tls.Certificate{cert}}))
+	require.NoError(t, err)
+	address := TListenAndServe(t, server)
+
+	dial := func(t *testing.T, protocols ...string) (*tls.Conn, error) {
+		conn, err := net.Dial("tcp", address.String())
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close() }) //nolint:errcheck
+
+		secure := tls.Client(conn, &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec
+			NextProtos:         protocols,
+		})
+
+		return secure, secure.Handshake()
+	}
+
+	t.Run("postgresql protocol", func(t *testing.T) {
+		conn, err := dial(t, alpnProtocol)
+		require.NoError(t, err)
+		assert.Equal(t, alpnProtocol, conn.ConnectionState().NegotiatedProtocol)
+
+		client := mock.NewClient(t, conn)
+		client.Handshake(t)
+		client.Authenticate(t)
+		client.ReadyForQuery(t, types.ServerIdle)
+		client.Close(t)
+	})
+
+	t.Run("missing protocol", func(t *testing.T) {
+		conn, err := dial(t)
+		require.NoError(t, err)
+
+		client := mock.NewClient(t, conn)
+		client.Handshake(t)
+
+		_, _, err = client.ReadTypedMsg()
+		require.Error(t, err)
+	})
+
+	t.Run("unknown protocol", func(t *testing.T) {
+		_, err := dial(t, "http/1.1")
+		require.Error(t, err)
+	})
+}
+
+func TestTLSGetCertificate(t *testing.T) {
+	t.Parallel()
+
+	cert, err := generateTestCert()
+	require.NoError(t, err)
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	config := &tls.Config{
+		GetCertificate: func(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		},
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), TLSConfig(config))
+	require.NoError(t, err)
+	address := TListenAndServe(t, server)
+
+	t.Run("ssl request", func(t *testing.T) {
+		ctx := context.Background()
+		config, err := pgx.ParseConfig(fmt.Sprintf("postgres://%s:%d?sslmode=require", address.IP, address.Port))
+		require.NoError(t, err)
+
+		config.TLSConfig = &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec
+		}
+
+		conn, err := pgx.ConnectConfig(ctx, config)
+		require.NoError(t, err)
+		require.NoError(t, conn.Ping(ctx))
+		require.NoError(t, conn.Close(ctx))
+	})
+
+	t.Run("direct", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address.String())
+		require.NoError(t, err)
+		defer conn.Close() //nolint:errcheck
+
+		secure := tls.Client(conn, &tls.Config{
+			InsecureSkipVerify: true, //nolint:gosec
+			NextProtos:         []string{alpnProtocol},
+		})
+
+		require.NoError(t, secure.Handshake())
+
+		client := mock.NewClient(t, secure)
+		client.Handshake(t)
+		client.Authenticate(t)
+		client.ReadyForQuery(t, types.ServerIdle)
+		client.Close(t)
+	})
+}
+
+func TestSecureConnNextProtos(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]struct {
+		protocols []string
+		expected  []string
+	}{
+		"empty": {
+			expected: []string{alpnProtocol},
+		},
+		"custom": {
+			protocols: []string{"custom"},
+			expected:  []string{alpnProtocol, "custom"},
+		},
+		"present": {
+			protocols: []string{"custom", alpnProtocol},
+			expected:  []string{"custom", alpnProtocol},
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			config := &tls.Config{NextProtos: slices.Clone(test.protocols)}
+			prepared := (&secureConn{}).prepare(config)
+			assert.Equal(t, test.expected, prepared.NextProtos)
+			assert.Equal(t, test.protocols, config.NextProtos)
+		})
+	}
+}
+
+func TestDirectTLSUnsupported(t *testing.T) {
+	t.Parallel()
+
+	server, err := NewServer(nil, Logger(slogt.New(t)))
+	require.NoError(t, err)
+	address := TListenAndServe(t, server)
+
+	conn, err := net.Dial("tcp", address.String())
+	require.NoError(t, err)
+	defer conn.Close() //nolint:errcheck
+
+	secure := tls.Client(conn, &tls.Config{
+		InsecureSkipVerify: true, //nolint:gosec
+		NextProtos:         []string{alpnProtocol},
+	})
+
+	require.Error(t, secure.Handshake())
+}
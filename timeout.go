@@ -0,0 +1,295 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jeroenrinzema/psql-wire/codes"
+	pgerror "github.com/jeroenrinzema/psql-wire/errors"
+	"github.com/jeroenrinzema/psql-wire/pkg/buffer"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+)
+
+// timeoutErrorWriteTimeout is the maximum duration spent writing a timeout
+// error to a client before the connection is closed.
+const timeoutErrorWriteTimeout = 100 * time.Millisecond
+
+// newErrAuthenticationTimeout is returned when the client has not been
+// authenticated within the configured authentication timeout.
+func newErrAuthenticationTimeout() error {
+	err := errors.New("canceling authentication due to timeout")
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.ProtocolViolation), pgerror.LevelFatal)
+}
+
+// newErrStartupTimeout is returned when the client has not sent a complete
+// startup packet within the configured startup timeout.
+func newErrStartupTimeout() error {
+	err := errors.New("timeout while reading startup packet")
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.ProtocolViolation), pgerror.LevelFatal)
+}
+
+// NewErrIdleSessionTimeout is returned when the session has been idle for
+// longer than the configured idle session timeout.
+func NewErrIdleSessionTimeout() error {
+	err := errors.New("terminating connection due to idle-session timeout")
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.IdleSessionTimeout), pgerror.LevelFatal)
+}
+
+// NewErrIdleInTransactionSessionTimeout is returned when the session has been
+// idle inside a transaction for longer than the configured idle in transaction
+// session timeout.
+func NewErrIdleInTransactionSessionTimeout() error {
+	err := errors.New("terminating connection due to idle-in-transaction timeout")
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.IdleInTransactionSessionTimeout), pgerror.LevelFatal)
+}
+
+// NewErrStatementTimeout is returned when the executed statement has been
+// canceled since it exceeded the configured statement timeout.
+func NewErrStatementTimeout() error {
+	err := errors.New("canceling statement due to statement timeout")
+	return pgerror.WithSeverity(pgerror.WithCode(err, codes.QueryCanceled), pgerror.LevelError)
+}
+
+// connDeadline represents a deadline enforced on a connection together with
+// the error reported once the deadline has expired.
+type connDeadline struct {
+	at  time.Time
+	err error
+}
+
+// newConnDeadline constructs a new deadline expiring after the given timeout.
+// A zero deadline is returned if the timeout is disabled.
+func newConnDeadline(now time.Time, timeout time.Duration, err func() error) connDeadline {
+	if timeout <= 0 {
+		return connDeadline{}
+	}
+
+	return connDeadline{at: now.Add(timeout), err: err()}
+}
+
+// context returns a copy of the given context which is cancelled once the
+// deadline expires. The deadline error is used as the cancellation cause.
+func (deadline connDeadline) context(ctx context.Context) (context.Context, context.CancelFunc) {
+	if deadline.at.IsZero() {
+		return context.WithCancel(ctx)
+	}
+
+	return context.WithDeadlineCause(ctx, deadline.at, deadline.err)
+}
+
+// setConnDeadline sets the earliest of the given deadlines on the connection,
+// zero deadlines are ignored. The earliest deadline is returned. The deadline
+// of the connection is cleared whenever no deadline has been given.
+func setConnDeadline(conn net.Conn, deadlines ...connDeadline) (connDeadline, error) {
+	var earliest connDeadline
+	for _, deadline := range deadlines {
+		if deadline.at.IsZero() {
+			continue
+		}
+
+		if earliest.at.IsZero() || deadline.at.Before(earliest.at) {
+			earliest = deadline
+		}
+	}
+
+	return earliest, conn.SetDeadline(earliest.at)
+}
+
+// writeTimeoutError attempts to inform the client about the given timeout
+// error. The connection deadline has expired at this point, a short write
+// deadline is set to ensure that slow clients are not able to block the
+// connection from being closed.
+func (srv *Server) writeTimeoutError(conn net.Conn, err error) {
+	srv.logger.Debug("connection timed out", "err", err)
+
+	werr := conn.SetWriteDeadline(time.Now().Add(timeoutErrorWriteTimeout))
+	if werr != nil {
+		return
+	}
+
+	writer := buffer.NewWriter(srv.logger, conn)
+	writer.ErrorSanitizer = srv.ErrorSanitizer
+	WriteUnterminatedError(writer, err) //nolint:errcheck
+}
+
+// waitForCommand sets the read deadline of the connection using the timeout
+// matching the transaction status of the session whenever the session is idle.
+func (srv *Session) waitForCommand() error {
+	status, idle := srv.control.idle()
+	if !idle {
+		return nil
+	}
+
+	switch status {
+	case types.ServerIdle:
+		if srv.IdleSessionTimeout > 0 {
+			return srv.control.wait(srv.IdleSessionTimeout, NewErrIdleSessionTimeout())
+		}
+	case types.ServerTransactionBlock, types.ServerTransactionFailed:
+		if timeout := srv.idleInTransactionTimeout(); timeout > 0 {
+			return srv.control.wait(timeout, NewErrIdleInTransactionSessionTimeout())
+		}
+	}
+
+	return nil
+}
+
+const (
+	// ParamStatementTimeout is the name of the statement timeout parameter.
+	ParamStatementTimeout = "statement_timeout"
+	// ParamIdleInTransactionSessionTimeout is the name of the idle in
+	// transaction session timeout parameter.
+	ParamIdleInTransactionSessionTimeout = "idle_in_transaction_session_timeout"
+)
+
+// statementTimeout returns the statement timeout of the session.
+func (srv *Session) statementTimeout() time.Duration {
+	return srv.control.timeoutParam(ParamStatementTimeout, srv.StatementTimeout)
+}
+
+// idleInTransactionTimeout returns the idle in transaction session timeout of
+// the session.
+func (srv *Session) idleInTransactionTimeout() time.Duration {
+	return srv.control.timeoutParam(ParamIdleInTransactionSessionTimeout, srv.IdleInTransactionSessionTimeout)
+}
+
+// timeoutParam returns the value of the given timeout parameter overridden by
+// the session. The given fallback is returned if the parameter has not been
+// overridden.
+func (control *sessionControl) timeoutParam(name string, fallback time.Duration) time.Duration {
+	if control == nil {
+		return fallback
+	}
+
+	control.mu.Lock()
+	defer control.mu.Unlock()
+
+	if timeout, has := control.timeouts[name]; has {
+		return timeout
+	}
+
+	return fallback
+}
+
+// setTimeoutParam overrides the given timeout parameter of the session inside
+// the given context. The PostgreSQL value syntax is accepted (ex: 500, 30s or
+// 5min), values without a unit are interpreted as milliseconds. The override
+// is removed if the value is empty or DEFAULT.
+func setTimeoutParam(ctx context.Context, name, value string) error {
+	session, ok := GetSession(ctx)
+	if !ok || session.control == nil {
+		return errors.New("no active session available")
+	}
+
+	control := session.control
+	value = strings.Trim(strings.TrimSpace(value), "'")
+	if value == "" || strings.EqualFold(value, "default") {
+		control.mu.Lock()
+		defer control.mu.Unlock()
+
+		delete(control.timeouts, name)
+		return nil
+	}
+
+	timeout, err := parseTimeout(value)
+	if err != nil {
+		err = fmt.Errorf("invalid value for parameter %q: %q", name, value)
+		return pgerror.WithCode(err, codes.InvalidParameterValue)
+	}
+
+	control.mu.Lock()
+	defer control.mu.Unlock()
+
+	if control.timeouts == nil {
+		control.timeouts = make(map[string]time.Duration)
+	}
+
+	control.timeouts[name] = timeout
+	return nil
+}
+
+// timeoutUnits contains the units accepted by time based parameters.
+var timeoutUnits = map[string]time.Duration{
+	"us":  time.Microsecond,
+	"ms":  time.Millisecond,
+	"s":   time.Second,
+	"min": time.Minute,
+	"h":   time.Hour,
+	"d":   24 * time.Hour,
+}
+
+// parseTimeout parses the given time based parameter value. Values without a
+// unit are interpreted as milliseconds.
+func parseTimeout(value string) (time.Duration, error) {
+	index := strings.IndexFunc(value, func(r rune) bool {
+		return (r < '0' || r > '9') && r != '.'
+	})
+
+	number, unit := value, "ms"
+	if index >= 0 {
+		number, unit = value[:index], strings.TrimSpace(value[index:])
+	}
+
+	multiplier, has := timeoutUnits[unit]
+	if !has {
+		return 0, fmt.Errorf("invalid unit %q", unit)
+	}
+
+	amount, err := strconv.ParseFloat(number, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if amount < 0 || amount*float64(multiplier) > math.MaxInt32*float64(time.Millisecond) {
+		return 0, fmt.Errorf("value %q out of range", value)
+	}
+
+	return time.Duration(amount * float64(multiplier)), nil
+}
+
+// SetStatementTimeout overrides the statement timeout of the session,
+// equivalent to SET statement_timeout. The value follows the PostgreSQL syntax
+// (ex: 500, 30s or 5min), values without a unit are interpreted as
+// milliseconds. A value of 0 disables the timeout. The server default is
+// restored when the value is empty or DEFAULT, equivalent to RESET
+// statement_timeout.
+func SetStatementTimeout(ctx context.Context, value string) error {
+	return setTimeoutParam(ctx, ParamStatementTimeout, value)
+}
+
+// SetIdleInTransactionSessionTimeout overrides the idle in transaction session
+// timeout of the session, equivalent to SET
+// idle_in_transaction_session_timeout. The value follows the same syntax as
+// [SetStatementTimeout].
+func SetIdleInTransactionSessionTimeout(ctx context.Context, value string) error {
+	return setTimeoutParam(ctx, ParamIdleInTransactionSessionTimeout, value)
+}
+
+// GetStatementTimeout returns the statement timeout of the session inside the
+// given context. Zero is returned if no session is available.
+func GetStatementTimeout(ctx context.Context) time.Duration {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return 0
+	}
+
+	return session.statementTimeout()
+}
+
+// GetIdleInTransactionSessionTimeout returns the idle in transaction session
+// timeout of the session inside the given context. Zero is returned if no
+// session is available.
+func GetIdleInTransactionSessionTimeout(ctx context.Context) time.Duration {
+	session, ok := GetSession(ctx)
+	if !ok {
+		return 0
+	}
+
+	return session.idleInTransactionTimeout()
+}
@@ -0,0 +1,302 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/jeroenrinzema/psql-wire/codes"
+	"github.com/jeroenrinzema/psql-wire/pkg/mock"
+	"github.com/jeroenrinzema/psql-wire/pkg/types"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStartupTimeout(t *testing.T) {
+	t.Parallel()
+
+	validate := func(ctx context.Context, database, username, password string) (context.Context, bool, error) {
+		return ctx, true, nil
+	}
+
+	server, err := NewServer(nil,
+		Logger(slogt.New(t)),
+		StartupTimeout(50*time.Millisecond),
+		AuthenticationTimeout(250*time.Millisecond),
+		SessionAuthStrategy(ClearTextPassword(validate)),
+	)
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	t.Run("silent", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address.String())
+		require.NoError(t, err)
+		defer conn.Close() //nolint:errcheck
+
+		client := mock.NewClient(t, conn)
+		client.Error(t, "timeout while reading startup packet")
+	})
+
+	t.Run("slowloris", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address.String())
+		require.NoError(t, err)
+		defer conn.Close() //nolint:errcheck
+
+		// NOTE: the startup packet is sent one byte at a time, the deadline
+		// is not extended by the received bytes.
+		for _, b := range []byte{0, 0, 0, 8} {
+			_, err = conn.Write([]byte{b})
+			require.NoError(t, err)
+			time.Sleep(20 * time.Millisecond)
+		}
+
+		client := mock.NewClient(t, conn)
+		client.Error(t, "timeout while reading startup packet")
+	})
+
+	t.Run("authentication", func(t *testing.T) {
+		conn, err := net.Dial("tcp", address.String())
+		require.NoError(t, err)
+		defer conn.Close() //nolint:errcheck
+
+		client := mock.NewClient(t, conn)
+		client.Handshake(t)
+
+		typed, _, err := client.ReadTypedMsg()
+		require.NoError(t, err)
+		require.Equal(t, types.ServerAuth, typed)
+
+		start := time.Now()
+		client.Error(t, "canceling authentication due to timeout")
+		assert.Greater(t, time.Since(start), 100*time.Millisecond)
+	})
+}
+
+func TestIdleSessionTimeout(t *testing.T) {
+	t.Parallel()
+
+	var transaction atomic.Bool
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			switch query.Query {
+			case "BEGIN":
+				transaction.Store(true)
+			case "COMMIT":
+				transaction.Store(false)
+			case "SELECT pg_sleep(0.2)":
+				time.Sleep(200 * time.Millisecond)
+			}
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	status := func(ctx context.Context) types.ServerStatus {
+		if transaction.Load() {
+			return types.ServerTransactionBlock
+		}
+		return types.ServerIdle
+	}
+
+	var terminated atomic.Int32
+	server, err := NewServer(handler,
+		Logger(slogt.New(t)),
+		IdleSessionTimeout(100*time.Millisecond),
+		TxStatus(status),
+		TerminateConn(func(ctx context.Context) error {
+			terminated.Add(1)
+			return nil
+		}),
+	)
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	conn, err := pgx.Connect(context.Background(), fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(context.Background()) //nolint:errcheck
+
+	// NOTE: statements executing longer than the timeout are not affected
+	_, err = conn.Exec(context.Background(), "SELECT pg_sleep(0.2)")
+	require.NoError(t, err)
+
+	// NOTE: sessions inside a transaction are not subject to the timeout
+	_, err = conn.Exec(context.Background(), "BEGIN")
+	require.NoError(t, err)
+
+	time.Sleep(200 * time.Millisecond)
+	_, err = conn.Exec(context.Background(), "COMMIT")
+	require.NoError(t, err)
+
+	require.Eventually(t, func() bool {
+		return len(server.Sessions()) == 0
+	}, time.Second, 10*time.Millisecond)
+	assert.Equal(t, int32(1), terminated.Load())
+
+	_, err = conn.Exec(context.Background(), "SELECT 1")
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, string(codes.IdleSessionTimeout), pgErr.Code)
+	assert.Equal(t, "FATAL", pgErr.Severity)
+	assert.Equal(t, "terminating connection due to idle-session timeout", pgErr.Message)
+}
+
+func TestStatementTimeout(t *testing.T) {
+	t.Parallel()
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			var err error
+			switch {
+			case strings.HasPrefix(query.Query, "SET statement_timeout = "):
+				err = SetStatementTimeout(ctx, strings.TrimPrefix(query.Query, "SET statement_timeout = "))
+			case query.Query == "RESET statement_timeout":
+				err = SetStatementTimeout(ctx, "DEFAULT")
+			case query.Query == "SELECT pg_sleep(0.2)":
+				select {
+				case <-ctx.Done():
+					return ctx.Err()
+				case <-time.After(200 * time.Millisecond):
+				}
+			}
+
+			if err != nil {
+				return err
+			}
+
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), StatementTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	conn, err := pgx.Connect(context.Background(), fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(context.Background()) //nolint:errcheck
+
+	ctx := context.Background()
+	modes := []pgx.QueryExecMode{pgx.QueryExecModeSimpleProtocol, pgx.QueryExecModeExec}
+	for _, mode := range modes {
+		_, err = conn.Exec(ctx, "SELECT pg_sleep(0.2)", mode)
+
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, string(codes.QueryCanceled), pgErr.Code)
+		assert.Equal(t, "ERROR", pgErr.Severity)
+		assert.Equal(t, "canceling statement due to statement timeout", pgErr.Message)
+	}
+
+	_, err = conn.Exec(ctx, "SET statement_timeout = '1s'", pgx.QueryExecModeSimpleProtocol)
+	require.NoError(t, err)
+
+	for _, mode := range modes {
+		_, err = conn.Exec(ctx, "SELECT pg_sleep(0.2)", mode)
+		require.NoError(t, err)
+	}
+
+	_, err = conn.Exec(ctx, "SET statement_timeout = 'forever'", pgx.QueryExecModeSimpleProtocol)
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, string(codes.InvalidParameterValue), pgErr.Code)
+
+	_, err = conn.Exec(ctx, "RESET statement_timeout", pgx.QueryExecModeSimpleProtocol)
+	require.NoError(t, err)
+
+	_, err = conn.Exec(ctx, "SELECT pg_sleep(0.2)", pgx.QueryExecModeSimpleProtocol)
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, string(codes.QueryCanceled), pgErr.Code)
+}
+
+func TestIdleInTransactionSessionTimeout(t *testing.T) {
+	t.Parallel()
+
+	var transaction atomic.Bool
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			switch {
+			case query.Query == "BEGIN":
+				transaction.Store(true)
+			case query.Query == "COMMIT":
+				transaction.Store(false)
+			case strings.HasPrefix(query.Query, "SET idle_in_transaction_session_timeout = "):
+				err := SetIdleInTransactionSessionTimeout(ctx, strings.TrimPrefix(query.Query, "SET idle_in_transaction_session_timeout = "))
+				if err != nil {
+					return err
+				}
+			}
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	status := func(ctx context.Context) types.ServerStatus {
+		if transaction.Load() {
+			return types.ServerTransactionBlock
+		}
+		return types.ServerIdle
+	}
+
+	server, err := NewServer(handler,
+		Logger(slogt.New(t)),
+		TxStatus(status),
+		IdleInTransactionSessionTimeout(time.Second),
+	)
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	conn, err := pgx.Connect(context.Background(), fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(context.Background()) //nolint:errcheck
+
+	ctx := context.Background()
+	_, err = conn.Exec(ctx, "SET idle_in_transaction_session_timeout = 50ms")
+	require.NoError(t, err)
+
+	// NOTE: idle sessions outside of a transaction are not affected
+	time.Sleep(100 * time.Millisecond)
+	_, err = conn.Exec(ctx, "BEGIN")
+	require.NoError(t, err)
+
+	time.Sleep(100 * time.Millisecond)
+	_, err = conn.Exec(ctx, "COMMIT")
+
+	var pgErr *pgconn.PgError
+	require.ErrorAs(t, err, &pgErr)
+	assert.Equal(t, string(codes.IdleInTransactionSessionTimeout), pgErr.Code)
+	assert.Equal(t, "FATAL", pgErr.Severity)
+	assert.Equal(t, "terminating connection due to idle-in-transaction timeout", pgErr.Message)
+}
+
+func TestParseTimeout(t *testing.T) {
+	t.Parallel()
+
+	tests := map[string]time.Duration{
+		"0":      0,
+		"500":    500 * time.Millisecond,
+		"1.5s":   1500 * time.Millisecond,
+		"30 s":   30 * time.Second,
+		"5min":   5 * time.Minute,
+		"2h":     2 * time.Hour,
+		"1d":     24 * time.Hour,
+		"250us":  250 * time.Microsecond,
+		"100 ms": 100 * time.Millisecond,
+	}
+
+	for value, expected := range tests {
+		timeout, err := parseTimeout(value)
+		require.NoError(t, err, value)
+		assert.Equal(t, expected, timeout, value)
+	}
+
+	for _, value := range []string{"", "s", "10 years", "-1", "abc", "100000d"} {
+		_, err := parseTimeout(value)
+		assert.Error(t, err, value)
+	}
+}
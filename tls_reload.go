@@ -0,0 +1,188 @@
+package wire
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"log/slog"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultCertificateReloadInterval is the interval at which the certificate
+// files are checked for changes whenever no interval has been configured.
+const DefaultCertificateReloadInterval = time.Minute
+
+// CertificateReloadConfig represents the certificate files watched by the
+// server. The files are checked for changes at the configured interval.
+type CertificateReloadConfig struct {
+	// CertFile is the PEM encoded certificate (chain) presented to clients.
+	CertFile string
+	// KeyFile is the PEM encoded private key of the certificate.
+	KeyFile string
+	// CAFile contains the PEM encoded certificate authorities used to verify
+	// client certificates. The client CAs of the TLS config are left untouched
+	// when no CA file has been configured.
+	CAFile string
+	// Interval is the interval at which the files are checked for changes.
+	Interval time.Duration
+}
+
+// certificates represents a validated set of certificates loaded from disk.
+type certificates struct {
+	certificate *tls.Certificate
+	clientCAs   *x509.CertPool
+	checksum    []byte
+}
+
+// certificateReloader loads the configured certificate files and atomically
+// swaps the certificates whenever the files have changed.
+type certificateReloader struct {
+	config  CertificateReloadConfig
+	current atomic.Pointer[certificates]
+	// rejected contains the checksum of the files most recently rejected,
+	// preventing invalid files from being validated (and reported) over and
+	// over again.
+	rejected []byte
+}
+
+// newCertificateReloader constructs a new certificate reloader and loads the
+// configured certificate files.
+func newCertificateReloader(config CertificateReloadConfig) (*certificateReloader, error) {
+	if config.CertFile == "" || config.KeyFile == "" {
+		return nil, errors.New("certificate and key file are required")
+	}
+
+	if config.Interval <= 0 {
+		config.Interval = DefaultCertificateReloadInterval
+	}
+
+	reloader := &certificateReloader{config: config}
+	_, err := reloader.reload(time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// reload loads the configured certificate files and swaps the current
+// certificates if the files have changed. The files are validated before
+// being swapped, the current certificates are kept whenever the files are
+// invalid. True is returned if the certificates have been swapped.
+func (reloader *certificateReloader) reload(now time.Time) (bool, error) {
+	files := []string{reloader.config.CertFile, reloader.config.KeyFile}
+	if reloader.config.CAFile != "" {
+		files = append(files, reloader.config.CAFile)
+	}
+
+	contents := make([][]byte, len(files))
+	checksum := sha256.New()
+	for index, file := range files {
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return false, err
+		}
+
+		contents[index] = content
+		checksum.Write(content)
+	}
+
+	sum := checksum.Sum(nil)
+	if current := reloader.current.Load(); current != nil && bytes.Equal(current.checksum, sum) {
+		return false, nil
+	}
+
+	if bytes.Equal(reloader.rejected, sum) {
+		return false, nil
+	}
+
+	reloader.rejected = sum
+	certificate, err := tls.X509KeyPair(contents[0], contents[1])
+	if err != nil {
+		return false, fmt.Errorf("invalid certificate key pair: %w", err)
+	}
+
+	if certificate.Leaf == nil {
+		certificate.Leaf, err = x509.ParseCertificate(certificate.Certificate[0])
+		if err != nil {
+			return false, fmt.Errorf("invalid certificate: %w", err)
+		}
+	}
+
+	if now.After(certificate.Leaf.NotAfter) {
+		return false, fmt.Errorf("certificate has expired at %s", certificate.Leaf.NotAfter.Format(time.RFC3339))
+	}
+
+	var clientCAs *x509.CertPool
+	if reloader.config.CAFile != "" {
+		clientCAs = x509.NewCertPool()
+		if !clientCAs.AppendCertsFromPEM(contents[2]) {
+			return false, fmt.Errorf("no certificates found inside CA file %q", reloader.config.CAFile)
+		}
+	}
+
+	reloader.rejected = nil
+	reloader.current.Store(&certificates{
+		certificate: &certificate,
+		clientCAs:   clientCAs,
+		checksum:    sum,
+	})
+
+	return true, nil
+}
+
+// watch checks the certificate files for changes at the configured interval
+// until the given channel is closed. Rotation events are logged using the
+// given logger.
+func (reloader *certificateReloader) watch(logger *slog.Logger, closer <-chan struct{}) {
+	ticker := time.NewTicker(reloader.config.Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-closer:
+			return
+		case now := <-ticker.C:
+			reloaded, err := reloader.reload(now)
+			if err != nil {
+				logger.Error("unable to reload TLS certificates, continuing with the current certificates", slog.String("cert_file", reloader.config.CertFile), "err", err)
+				continue
+			}
+
+			if !reloaded {
+				continue
+			}
+
+			leaf := reloader.current.Load().certificate.Leaf
+			logger.Info("TLS certificates reloaded",
+				slog.String("cert_file", reloader.config.CertFile),
+				slog.String("subject", leaf.Subject.String()),
+				slog.String("serial", leaf.SerialNumber.String()),
+				slog.Time("not_after", leaf.NotAfter),
+			)
+		}
+	}
+}
+
+// apply returns a copy of the given TLS config using the current
+// certificates. A new TLS config is constructed if the given config is nil.
+func (reloader *certificateReloader) apply(config *tls.Config) *tls.Config {
+	if config == nil {
+		config = &tls.Config{}
+	} else {
+		config = config.Clone()
+	}
+
+	current := reloader.current.Load()
+	config.Certificates = []tls.Certificate{*current.certificate}
+	if current.clientCAs != nil {
+		config.ClientCAs = current.clientCAs
+	}
+
+	return config
+}
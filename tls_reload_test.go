@@ -0,0 +1,159 @@
+package wire
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// writeTestCertFiles writes the given certificate and its private key PEM
+// encoded to the given files.
+func writeTestCertFiles(t *testing.T, cert tls.Certificate, certFile, keyFile string) {
+	t.Helper()
+
+	key, err := x509.MarshalPKCS8PrivateKey(cert.PrivateKey)
+	require.NoError(t, err)
+
+	certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: cert.Certificate[0]})
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: key})
+
+	require.NoError(t, os.WriteFile(certFile, certPEM, 0o600))
+	require.NoError(t, os.WriteFile(keyFile, keyPEM, 0o600))
+}
+
+func TestCertificateReloader(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	initial, err := generateTestCert()
+	require.NoError(t, err)
+
+	writeTestCertFiles(t, initial, certFile, keyFile)
+
+	reloader, err := newCertificateReloader(CertificateReloadConfig{CertFile: certFile, KeyFile: keyFile})
+	require.NoError(t, err)
+	assert.Equal(t, DefaultCertificateReloadInterval, reloader.config.Interval)
+	assert.Equal(t, initial.Certificate[0], reloader.apply(nil).Certificates[0].Certificate[0])
+
+	reloaded, err := reloader.reload(time.Now())
+	require.NoError(t, err)
+	assert.False(t, reloaded)
+
+	t.Run("mismatched key pair", func(t *testing.T) {
+		other, err := generateTestCert()
+		require.NoError(t, err)
+
+		mismatched := tls.Certificate{Certificate: initial.Certificate, PrivateKey: other.PrivateKey}
+		writeTestCertFiles(t, mismatched, certFile, keyFile)
+
+		_, err = reloader.reload(time.Now())
+		require.Error(t, err)
+		assert.Equal(t, initial.Certificate[0], reloader.apply(nil).Certificates[0].Certificate[0])
+
+		// NOTE: rejected files are only reported once
+		reloaded, err := reloader.reload(time.Now())
+		require.NoError(t, err)
+		assert.False(t, reloaded)
+	})
+
+	t.Run("expired certificate", func(t *testing.T) {
+		expired, err := generateTestCert()
+		require.NoError(t, err)
+
+		writeTestCertFiles(t, expired, certFile, keyFile)
+
+		_, err = reloader.reload(time.Now().Add(24 * time.Hour))
+		require.Error(t, err)
+		assert.Equal(t, initial.Certificate[0], reloader.apply(nil).Certificates[0].Certificate[0])
+	})
+
+	t.Run("missing CA file", func(t *testing.T) {
+		_, err := newCertificateReloader(CertificateReloadConfig{CertFile: certFile, KeyFile: keyFile, CAFile: filepath.Join(dir, "ca.crt")})
+		require.Error(t, err)
+	})
+
+	t.Run("client CAs", func(t *testing.T) {
+		caFile := filepath.Join(dir, "ca.crt")
+		writeTestCertFiles(t, initial, caFile, filepath.Join(dir, "ca.key"))
+
+		reloader, err := newCertificateReloader(CertificateReloadConfig{CertFile: certFile, KeyFile: keyFile, CAFile: caFile})
+		require.NoError(t, err)
+		assert.NotNil(t, reloader.apply(&tls.Config{}).ClientCAs)
+	})
+}
+
+func TestReloadCertificates(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	certFile := filepath.Join(dir, "tls.crt")
+	keyFile := filepath.Join(dir, "tls.key")
+
+	initial, err := generateTestCert()
+	require.NoError(t, err)
+
+	rotated, err := generateTestCert()
+	require.NoError(t, err)
+
+	writeTestCertFiles(t, initial, certFile, keyFile)
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), ReloadCertificates(CertificateReloadConfig{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+		Interval: 10 * time.Millisecond,
+	}))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connect := func(t *testing.T) *pgx.Conn {
+		ctx := context.Background()
+		config, err := pgx.ParseConfig(fmt.Sprintf("postgres://%s:%d?sslmode=require", address.IP, address.Port))
+		require.NoError(t, err)
+
+		config.TLSConfig = &tls.Config{InsecureSkipVerify: true} //nolint:gosec
+		conn, err := pgx.ConnectConfig(ctx, config)
+		require.NoError(t, err)
+		t.Cleanup(func() { conn.Close(ctx) }) //nolint:errcheck
+		return conn
+	}
+
+	presented := func(conn *pgx.Conn) []byte {
+		state := conn.PgConn().Conn().(*tls.Conn).ConnectionState()
+		return state.PeerCertificates[0].Raw
+	}
+
+	established := connect(t)
+	require.Equal(t, initial.Certificate[0], presented(established))
+
+	writeTestCertFiles(t, rotated, certFile, keyFile)
+
+	require.Eventually(t, func() bool {
+		conn := connect(t)
+		return assert.ObjectsAreEqual(rotated.Certificate[0], presented(conn))
+	}, 5*time.Second, 20*time.Millisecond)
+
+	// NOTE: established connections are left untouched
+	assert.Equal(t, initial.Certificate[0], presented(established))
+	require.NoError(t, established.Ping(context.Background()))
+}
@@ -0,0 +1,124 @@
+package wire
+
+import (
+	"errors"
+	"fmt"
+	"io/fs"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// UnixSocketPath returns the path of the Unix domain socket inside the given
+// directory for the given port following the PostgreSQL naming convention
+// (ex: /tmp/.s.PGSQL.5432).
+func UnixSocketPath(dir string, port int) string {
+	return filepath.Join(dir, fmt.Sprintf(".s.PGSQL.%d", port))
+}
+
+// ListenAndServeUnix opens a new Unix domain socket inside the given directory
+// using the PostgreSQL naming convention for the given port, allowing libpq
+// based clients to connect using host=<dir> port=<port>. A lock file
+// (.s.PGSQL.<port>.lock) is created alongside the socket preventing multiple
+// servers from using the same socket. Stale sockets and lock files of servers
+// which are no longer running are removed. The socket and lock file are
+// removed once the server is closed.
+func (srv *Server) ListenAndServeUnix(dir string, port int) error {
+	path := UnixSocketPath(dir, port)
+	lock := path + ".lock"
+
+	err := createSocketLockFile(lock, dir, port)
+	if err != nil {
+		return err
+	}
+
+	defer os.Remove(lock) //nolint:errcheck
+
+	// NOTE: the lock file is owned by this server, any existing socket file
+	// is a leftover of a server which is no longer running.
+	err = os.Remove(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	listener, err := net.Listen("unix", path)
+	if err != nil {
+		return err
+	}
+
+	// NOTE: matches the PostgreSQL default unix_socket_permissions. Access
+	// should be restricted using the directory permissions or authentication.
+	err = os.Chmod(path, 0o777)
+	if err != nil {
+		listener.Close() //nolint:errcheck
+		return err
+	}
+
+	return srv.Serve(listener)
+}
+
+// createSocketLockFile creates the socket lock file at the given path. An
+// error is returned if the lock file is held by another running process.
+func createSocketLockFile(path, dir string, port int) error {
+	content := fmt.Sprintf("%d\n\n%d\n%d\n%s\n", os.Getpid(), time.Now().Unix(), port, dir)
+
+	for attempt := 0; ; attempt++ {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o600)
+		if err == nil {
+			_, err = file.WriteString(content)
+			if err != nil {
+				file.Close()    //nolint:errcheck
+				os.Remove(path) //nolint:errcheck
+				return err
+			}
+
+			return file.Close()
+		}
+
+		if !errors.Is(err, fs.ErrExist) || attempt > 0 {
+			return fmt.Errorf("could not create lock file %q: %w", path, err)
+		}
+
+		if socketLockFileActive(path) {
+			return fmt.Errorf("lock file %q already exists, is another server running on socket %q?", path, strings.TrimSuffix(path, ".lock"))
+		}
+
+		err = os.Remove(path)
+		if err != nil && !errors.Is(err, fs.ErrNotExist) {
+			return err
+		}
+	}
+}
+
+// socketLockFileActive checks whether the process owning the given lock file
+// is still running. Lock files which could not be read are considered active.
+func socketLockFileActive(path string) bool {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return true
+	}
+
+	line, _, _ := strings.Cut(string(content), "\n")
+	pid, err := strconv.Atoi(strings.TrimSpace(line))
+	if err != nil || pid <= 0 {
+		// NOTE: malformed lock files could not have been written by a running
+		// server and are considered stale.
+		return false
+	}
+
+	if pid == os.Getpid() {
+		return true
+	}
+
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	err = process.Signal(syscall.Signal(0))
+	return err == nil || errors.Is(err, syscall.EPERM)
+}
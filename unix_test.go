@@ -0,0 +1,176 @@
+package wire
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/user"
+	"runtime"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// TListenAndServeUnix starts serving the given server on a Unix domain socket
+// inside a temporary directory. The socket directory is returned once the
+// socket has been created.
+func TListenAndServeUnix(t *testing.T, server *Server, port int) string {
+	// NOTE: Unix domain socket paths are limited in length, the test
+	// directory is kept short to avoid exceeding the limit.
+	dir, err := os.MkdirTemp("", "pgwire")
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServeUnix(dir, port)
+	}()
+
+	t.Cleanup(func() {
+		require.NoError(t, server.Close())
+		server.Wait()
+		require.NoError(t, <-served)
+		os.RemoveAll(dir) //nolint:errcheck
+	})
+
+	require.Eventually(t, func() bool {
+		_, err := os.Stat(UnixSocketPath(dir, port))
+		return err == nil
+	}, time.Second, 10*time.Millisecond)
+
+	return dir
+}
+
+func TestListenAndServeUnix(t *testing.T) {
+	t.Parallel()
+
+	server, err := NewServer(nil, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	dir := TListenAndServeUnix(t, server, 5432)
+
+	lock, err := os.ReadFile(UnixSocketPath(dir, 5432) + ".lock")
+	require.NoError(t, err)
+	assert.Contains(t, string(lock), strconv.Itoa(os.Getpid())+"\n")
+
+	info, err := os.Stat(UnixSocketPath(dir, 5432))
+	require.NoError(t, err)
+	assert.Equal(t, os.ModeSocket, info.Mode().Type())
+
+	t.Run("jackc/pgx", func(t *testing.T) {
+		ctx := context.Background()
+		conn, err := pgx.Connect(ctx, fmt.Sprintf("host=%s port=5432 user=admin sslmode=disable", dir))
+		require.NoError(t, err)
+		require.NoError(t, conn.Close(ctx))
+	})
+
+	t.Run("lock file", func(t *testing.T) {
+		other, err := NewServer(nil, Logger(slogt.New(t)))
+		require.NoError(t, err)
+
+		err = other.ListenAndServeUnix(dir, 5432)
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "is another server running")
+	})
+}
+
+func TestListenAndServeUnixStaleLockFile(t *testing.T) {
+	t.Parallel()
+
+	dir, err := os.MkdirTemp("", "pgwire")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir) //nolint:errcheck
+
+	// NOTE: the lock file contains a malformed pid and is therefore stale
+	lock := UnixSocketPath(dir, 5433) + ".lock"
+	require.NoError(t, os.WriteFile(lock, []byte("-1\n"), 0o600))
+	require.NoError(t, os.WriteFile(UnixSocketPath(dir, 5433), nil, 0o600))
+
+	server, err := NewServer(nil, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	served := make(chan error, 1)
+	go func() {
+		served <- server.ListenAndServeUnix(dir, 5433)
+	}()
+
+	require.Eventually(t, func() bool {
+		info, err := os.Stat(UnixSocketPath(dir, 5433))
+		return err == nil && info.Mode().Type() == os.ModeSocket
+	}, time.Second, 10*time.Millisecond)
+
+	require.NoError(t, server.Close())
+	server.Wait()
+	require.NoError(t, <-served)
+
+	_, err = os.Stat(lock)
+	assert.ErrorIs(t, err, os.ErrNotExist)
+
+	_, err = os.Stat(UnixSocketPath(dir, 5433))
+	assert.ErrorIs(t, err, os.ErrNotExist)
+}
+
+func TestPeer(t *testing.T) {
+	t.Parallel()
+
+	if runtime.GOOS != "linux" {
+		t.Skip("peer credentials are only supported on linux")
+	}
+
+	current, err := user.Current()
+	require.NoError(t, err)
+
+	mapper := func(ctx context.Context, systemUser string, username string) (context.Context, bool, error) {
+		cred := PeerCredentials(ctx)
+		require.NotNil(t, cred)
+		assert.Equal(t, int32(os.Getpid()), cred.PID)
+		return ctx, systemUser == current.Username && username == "mapped", nil
+	}
+
+	tests := map[string]struct {
+		mapper PeerMapper
+		user   string
+		err    bool
+	}{
+		"system user":          {user: current.Username},
+		"system user mismatch": {user: "other", err: true},
+		"mapped user":          {mapper: mapper, user: "mapped"},
+		"mapped user mismatch": {mapper: mapper, user: current.Username, err: true},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			server, err := NewServer(nil, Logger(slogt.New(t)), SessionAuthStrategy(Peer(test.mapper)))
+			require.NoError(t, err)
+
+			dir := TListenAndServeUnix(t, server, 5432)
+
+			ctx := context.Background()
+			conn, err := pgx.Connect(ctx, fmt.Sprintf("host=%s port=5432 user=%s sslmode=disable", dir, test.user))
+			if test.err {
+				require.Error(t, err)
+				assert.Contains(t, err.Error(), "peer authentication failed")
+				return
+			}
+
+			require.NoError(t, err)
+			require.NoError(t, conn.Close(ctx))
+		})
+	}
+
+	t.Run("tcp", func(t *testing.T) {
+		server, err := NewServer(nil, Logger(slogt.New(t)), SessionAuthStrategy(Peer(nil)))
+		require.NoError(t, err)
+
+		address := TListenAndServe(t, server)
+
+		ctx := context.Background()
+		_, err = pgx.Connect(ctx, fmt.Sprintf("postgres://%s@%s:%d?sslmode=disable", current.Username, address.IP, address.Port))
+		require.Error(t, err)
+		assert.Contains(t, err.Error(), "only supported on local sockets")
+	})
+}
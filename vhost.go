@@ -0,0 +1,129 @@
+package wire
+
+import (
+	"context"
+	"crypto/tls"
+	"strings"
+)
+
+// VirtualHostConfig represents a logical server hosted behind the same
+// listener. Virtual hosts are selected using the server name (SNI) sent by the
+// client during the TLS handshake. Fields which have not been set fall back to
+// the configuration of the server.
+type VirtualHostConfig struct {
+	// TLSConfig is used to complete the TLS handshake of clients connecting
+	// to the virtual host, allowing certificates to be configured per host.
+	TLSConfig *tls.Config
+	// Parse is used to parse the queries of clients connected to the virtual
+	// host.
+	Parse ParseFn
+	// Auth is used to authenticate clients connecting to the virtual host.
+	Auth AuthStrategy
+	// Parameters are send back to clients connected to the virtual host once
+	// a handshake has been established.
+	Parameters Parameters
+}
+
+// virtualHost returns the virtual host configured for the given server name.
+// Exact matches take precedence over wildcard matches (ex: *.example.com). Nil
+// is returned if no virtual host matches the given server name.
+func (srv *Server) virtualHost(serverName string) *VirtualHostConfig {
+	if serverName == "" || len(srv.VirtualHosts) == 0 {
+		return nil
+	}
+
+	serverName = strings.ToLower(strings.TrimSuffix(serverName, "."))
+	if host, has := srv.VirtualHosts[serverName]; has {
+		return host
+	}
+
+	_, parent, ok := strings.Cut(serverName, ".")
+	if !ok {
+		return nil
+	}
+
+	return srv.VirtualHosts["*."+parent]
+}
+
+// tlsConfig returns the TLS config used to upgrade client connections. The
+// certificates most recently loaded by the certificate reloader are used when
+// configured. The TLS config of the virtual host matching the server name sent
+// by the client is used during the TLS handshake when configured. Virtual
+// hosts are only consulted if any of them is able to present a certificate.
+func (srv *Server) tlsConfig() *tls.Config {
+	config := srv.TLSConfig
+	if srv.tlsReloader != nil {
+		config = srv.tlsReloader.apply(config)
+	}
+
+	if !srv.virtualHostCertificates() {
+		return config
+	}
+
+	if config == nil {
+		config = &tls.Config{}
+	}
+
+	config = config.Clone()
+	getConfigForClient := config.GetConfigForClient
+	config.GetConfigForClient = func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+		host := srv.virtualHost(hello.ServerName)
+		if host != nil && host.TLSConfig != nil {
+			return host.TLSConfig, nil
+		}
+
+		if getConfigForClient != nil {
+			return getConfigForClient(hello)
+		}
+
+		return nil, nil
+	}
+
+	return config
+}
+
+// virtualHostCertificates checks whether any of the configured virtual hosts
+// is able to present a certificate to clients.
+func (srv *Server) virtualHostCertificates() bool {
+	for _, host := range srv.VirtualHosts {
+		if hasCertificates(host.TLSConfig) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// authStrategy returns the authentication strategy used to authenticate the
+// client connected to the given context.
+func (srv *Server) authStrategy(ctx context.Context) AuthStrategy {
+	if host := MatchedVirtualHost(ctx); host != nil && host.Auth != nil {
+		return host.Auth
+	}
+
+	return srv.Auth
+}
+
+// parameters returns the server parameters send back to the client connected
+// to the given context.
+func (srv *Server) parameters(ctx context.Context) Parameters {
+	if host := MatchedVirtualHost(ctx); host != nil && host.Parameters != nil {
+		return host.Parameters
+	}
+
+	return srv.Parameters
+}
+
+// parseFn returns the parse function used to parse the queries of the session.
+func (srv *Session) parseFn() ParseFn {
+	if srv.host != nil && srv.host.Parse != nil {
+		return srv.host.Parse
+	}
+
+	return srv.parse
+}
+
+// parseQuery parses the given query using the parse function of the session.
+func (srv *Session) parseQuery(ctx context.Context, query Query) (PreparedStatements, error) {
+	return srv.parseFn()(ctx, query)
+}
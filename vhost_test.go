@@ -0,0 +1,195 @@
+package wire
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVirtualHosts(t *testing.T) {
+	t.Parallel()
+
+	defaultCert, err := generateTestCert()
+	require.NoError(t, err)
+
+	hostCert, err := generateTestCert()
+	require.NoError(t, err)
+
+	handler := func(name string) ParseFn {
+		return func(ctx context.Context, query Query) (PreparedStatements, error) {
+			return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+				writer.Row([]any{name + ":" + ServerName(ctx)}) //nolint:errcheck
+				return writer.Complete("SELECT 1")
+			}, WithColumns(Columns{{Name: "host", Oid: pgtype.TextOID}}))), nil
+		}
+	}
+
+	reject := func(ctx context.Context, database, username, password string) (context.Context, bool, error) {
+		return ctx, false, nil
+	}
+
+	server, err := NewServer(handler("default"),
+		Logger(slogt.New(t)),
+		TLSConfig(&tls.Config{Certificates: []tls.Certificate{defaultCert}}),
+		GlobalParameters(Parameters{"cluster": "default"}),
+		VirtualHost("alpha.example.com", VirtualHostConfig{
+			TLSConfig:  &tls.Config{Certificates: []tls.Certificate{hostCert}},
+			Parse:      handler("alpha"),
+			Parameters: Parameters{"cluster": "alpha"},
+		}),
+		VirtualHost("*.beta.example.com", VirtualHostConfig{
+			Parse: handler("beta"),
+		}),
+		VirtualHost("locked.example.com", VirtualHostConfig{
+			Auth: ClearTextPassword(reject),
+		}),
+	)
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+
+	connect := func(t *testing.T, serverName string) (*pgx.Conn, error) {
+		ctx := context.Background()
+		config, err := pgx.ParseConfig(fmt.Sprintf("postgres://%s:%d?sslmode=require", address.IP, address.Port))
+		require.NoError(t, err)
+
+		config.Password = "secret"
+		config.TLSConfig = &tls.Config{
+			ServerName:         serverName,
+			InsecureSkipVerify: true, //nolint:gosec
+		}
+
+		conn, err := pgx.ConnectConfig(ctx, config)
+		if err == nil {
+			t.Cleanup(func() { conn.Close(ctx) }) //nolint:errcheck
+		}
+
+		return conn, err
+	}
+
+	tests := map[string]struct {
+		serverName  string
+		certificate tls.Certificate
+		host        string
+		cluster     string
+	}{
+		"exact match": {
+			serverName:  "alpha.example.com",
+			certificate: hostCert,
+			host:        "alpha:alpha.example.com",
+			cluster:     "alpha",
+		},
+		"case insensitive match": {
+			serverName:  "ALPHA.example.com",
+			certificate: hostCert,
+			host:        "alpha:ALPHA.example.com",
+			cluster:     "alpha",
+		},
+		"wildcard match": {
+			serverName:  "db.beta.example.com",
+			certificate: defaultCert,
+			host:        "beta:db.beta.example.com",
+			cluster:     "default",
+		},
+		"unknown host": {
+			serverName:  "gamma.example.com",
+			certificate: defaultCert,
+			host:        "default:gamma.example.com",
+			cluster:     "default",
+		},
+	}
+
+	for name, test := range tests {
+		t.Run(name, func(t *testing.T) {
+			conn, err := connect(t, test.serverName)
+			require.NoError(t, err)
+
+			state := conn.PgConn().Conn().(*tls.Conn).ConnectionState()
+			require.NotEmpty(t, state.PeerCertificates)
+			assert.Equal(t, test.certificate.Certificate[0], state.PeerCertificates[0].Raw)
+			assert.Equal(t, test.cluster, conn.PgConn().ParameterStatus("cluster"))
+
+			var host string
+			err = conn.QueryRow(context.Background(), "SELECT host").Scan(&host)
+			require.NoError(t, err)
+			assert.Equal(t, test.host, host)
+		})
+	}
+
+	t.Run("auth strategy", func(t *testing.T) {
+		_, err := connect(t, "locked.example.com")
+		require.Error(t, err)
+	})
+}
+
+func TestVirtualHostsWithoutTLSConfig(t *testing.T) {
+	t.Parallel()
+
+	cert, err := generateTestCert()
+	require.NoError(t, err)
+
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		return Prepared(NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			return writer.Complete("OK")
+		})), nil
+	}
+
+	connect := func(t *testing.T, address *net.TCPAddr, sslmode string, serverName string) error {
+		ctx := context.Background()
+		config, err := pgx.ParseConfig(fmt.Sprintf("postgres://%s:%d?sslmode=%s", address.IP, address.Port, sslmode))
+		require.NoError(t, err)
+
+		if config.TLSConfig != nil {
+			config.TLSConfig.ServerName = serverName
+			config.TLSConfig.InsecureSkipVerify = true
+		}
+
+		conn, err := pgx.ConnectConfig(ctx, config)
+		if err != nil {
+			return err
+		}
+
+		return conn.Close(ctx)
+	}
+
+	t.Run("host certificate", func(t *testing.T) {
+		t.Parallel()
+
+		server, err := NewServer(handler, Logger(slogt.New(t)), VirtualHost("alpha.example.com", VirtualHostConfig{
+			TLSConfig: &tls.Config{Certificates: []tls.Certificate{cert}},
+		}))
+		require.NoError(t, err)
+
+		address := TListenAndServe(t, server)
+		require.NoError(t, connect(t, address, "require", "alpha.example.com"))
+	})
+
+	t.Run("no certificates", func(t *testing.T) {
+		t.Parallel()
+
+		server, err := NewServer(handler, Logger(slogt.New(t)), VirtualHost("alpha.example.com", VirtualHostConfig{}))
+		require.NoError(t, err)
+
+		address := TListenAndServe(t, server)
+		require.NoError(t, connect(t, address, "prefer", "alpha.example.com"))
+		require.Error(t, connect(t, address, "require", "alpha.example.com"))
+	})
+}
+
+func TestVirtualHostOption(t *testing.T) {
+	t.Parallel()
+
+	_, err := NewServer(nil, VirtualHost("", VirtualHostConfig{}))
+	require.Error(t, err)
+
+	_, err = NewServer(nil, VirtualHost("a.example.com", VirtualHostConfig{}), VirtualHost("A.example.com.", VirtualHostConfig{}))
+	require.Error(t, err)
+}
@@ -96,6 +96,8 @@ func NewServer(parse ParseFn, options ...OptionFn) (*Server, error) {
 		Portals:         DefaultPortalCacheFn,
 		Session:         func(ctx context.Context) (context.Context, error) { return ctx, nil },
 		ShutdownTimeout: 1 * time.Second,
+		connections:     newConnectionTracker(),
+		sessions:        newSessionRegistry(),
 	}
 
 	for _, option := range options {
@@ -111,6 +113,9 @@ func NewServer(parse ParseFn, options ...OptionFn) (*Server, error) {
 // Server contains options for listening to an address.
 type Server struct {
 	closing atomic.Bool
+	// draining is set during a smart shutdown, sessions continue to be served
+	// until they have been closed by their clients.
+	draining atomic.Bool
 	// Used to make reading closing and calling wg.Add be a single atomic operation.
 	closingMu sync.RWMutex
 	// This WaitGroup tracks the number of connections that are actively
@@ -146,8 +151,57 @@ type Server struct {
 	TxStatus         TxStatusFn
 	Version          string
 	ShutdownTimeout  time.Duration
-	typeExtension    func(*pgtype.Map)
-	closer           chan struct{}
+	ShutdownMode     ShutdownMode
+	RoleLookup       RoleLookupFn
+	// VirtualHosts contains the virtual hosts served by the server indexed by
+	// their lower case server name.
+	VirtualHosts map[string]*VirtualHostConfig
+	// ProxyTrustedNetworks contains the networks of the proxies which are
+	// trusted to send a PROXY protocol header.
+	ProxyTrustedNetworks []*net.IPNet
+	// connections tracks the authenticated connections and enforces the
+	// configured connection limits.
+	connections *connectionTracker
+	// sessions contains the sessions currently served by the server.
+	sessions *sessionRegistry
+	// tlsReloader reloads the TLS certificates whenever the certificate files
+	// have changed. The reloader is started once the server starts serving.
+	tlsReloader     *certificateReloader
+	tlsReloaderOnce sync.Once
+	// StartupTimeout is the maximum duration from accepting the connection
+	// until the startup packet has been received. A timeout of 0 disables the
+	// timeout.
+	StartupTimeout time.Duration
+	// IdleSessionTimeout is the maximum duration a session is allowed to be
+	// idle, outside of a transaction, before the session is terminated
+	// (idle_session_timeout). A timeout of 0 disables the timeout.
+	IdleSessionTimeout time.Duration
+	// IdleInTransactionSessionTimeout is the maximum duration a session is
+	// allowed to be idle inside a transaction before the session is
+	// terminated (idle_in_transaction_session_timeout). A timeout of 0
+	// disables the timeout.
+	IdleInTransactionSessionTimeout time.Duration
+	// StatementTimeout is the maximum duration of a statement before the
+	// statement is canceled (statement_timeout). A timeout of 0 disables the
+	// timeout.
+	StatementTimeout time.Duration
+	// AuthenticationTimeout is the maximum duration of the startup phase,
+	// from accepting the connection until the client has been authenticated.
+	// A timeout of 0 disables the timeout.
+	AuthenticationTimeout time.Duration
+	// FlushThreshold is the amount of bytes which are buffered before the
+	// messages written to a session are flushed to the client. Messages are
+	// additionally flushed at ReadyForQuery, PortalSuspended, client Flush
+	// messages and whenever a handler requests a flush through [Flusher]. The
+	// default flush threshold is used whenever a threshold of 0 is configured,
+	// a negative threshold disables output buffering.
+	FlushThreshold int
+	// ReadBufferSize is the size of the read buffer which is allocated for
+	// each connection to buffer incoming data. The maximum message size is
+	// configured separately (BufferedMsgSize), message buffers grow on demand.
+	ReadBufferSize int
+	typeExtension  func(*pgtype.Map)
+	closer         chan struct{}
 }
 
 // ListenAndServe opens a new Postgres server on the preconfigured address and
@@ -172,6 +226,11 @@ func (srv *Server) Serve(listener net.Listener) error {
 		return nil
 	}
 	srv.wg.Add(1)
+	if srv.tlsReloader != nil {
+		srv.tlsReloaderOnce.Do(func() {
+			srv.wg.Go(func() { srv.tlsReloader.watch(srv.logger, srv.closer) })
+		})
+	}
 	srv.closingMu.RUnlock()
 
 	defer srv.wg.Done()
@@ -227,16 +286,62 @@ func (srv *Server) newTypeMap() *pgtype.Map {
 	return m
 }
 
-func (srv *Server) serve(ctx context.Context, conn net.Conn) error {
+func (srv *Server) serve(ctx context.Context, conn net.Conn) (err error) {
 	// Create a per-connection pgx Map to avoid concurrent map writes
 	// Each connection gets its own type map instance to prevent race conditions
 	// when multiple goroutines access the same map concurrently during query execution
 	ctx = setTypeInfo(ctx, srv.newTypeMap())
-	ctx = setRemoteAddress(ctx, conn.RemoteAddr())
 	defer conn.Close() //nolint:errcheck
 
+	started := time.Now()
+
+	// NOTE: the PROXY protocol header has to be consumed before the remote
+	// address is recorded since it contains the original client address.
+	if srv.trustedProxy(conn) {
+		proxied, err := srv.readProxyHeader(conn)
+		if err != nil {
+			return err
+		}
+
+		conn = proxied
+		ctx = setProxyHeader(ctx, proxied.header)
+	}
+
+	ctx = setRemoteAddress(ctx, conn.RemoteAddr())
+
+	if unix, ok := conn.(*net.UnixConn); ok {
+		cred, err := peerCredentials(unix)
+		if err != nil {
+			srv.logger.Debug("unable to retrieve peer credentials", "err", err)
+		} else {
+			ctx = setPeerCredentials(ctx, cred)
+		}
+	}
+
 	srv.logger.Debug("serving a new client connection")
 
+	// NOTE: the startup timeout covers the startup phase until the startup
+	// packet has been received, the authentication timeout covers the entire
+	// startup phase. The client is informed about the timeout whenever
+	// possible.
+	now := time.Now()
+	startupDeadline := newConnDeadline(now, srv.StartupTimeout, newErrStartupTimeout)
+	authDeadline := newConnDeadline(now, srv.AuthenticationTimeout, newErrAuthenticationTimeout)
+
+	var deadline connDeadline
+	if srv.StartupTimeout > 0 || srv.AuthenticationTimeout > 0 {
+		deadline, err = setConnDeadline(conn, startupDeadline, authDeadline)
+		if err != nil {
+			return err
+		}
+
+		defer func() {
+			if deadline.err != nil && (errors.Is(err, os.ErrDeadlineExceeded) || errors.Is(err, deadline.err)) {
+				srv.writeTimeoutError(conn, deadline.err)
+			}
+		}()
+	}
+
 	conn, version, reader, err := srv.Handshake(conn)
 	if err != nil {
 		return err
@@ -246,6 +351,13 @@ func (srv *Server) serve(ctx context.Context, conn net.Conn) error {
 		return conn.Close()
 	}
 
+	if secure, ok := conn.(*secureConn); ok {
+		ctx = setSecureConn(ctx, secure)
+		if host := srv.virtualHost(ServerName(ctx)); host != nil {
+			ctx = setVirtualHost(ctx, host)
+		}
+	}
+
 	srv.logger.Debug("handshake successful, validating authentication")
 
 	writer := buffer.NewWriter(srv.logger, conn)
@@ -264,6 +376,13 @@ func (srv *Server) serve(ctx context.Context, conn net.Conn) error {
 		return err
 	}
 
+	if srv.StartupTimeout > 0 {
+		deadline, err = setConnDeadline(conn, authDeadline)
+		if err != nil {
+			return err
+		}
+	}
+
 	// A NegotiateProtocolVersion message must be sent (when applicable) after
 	// the startup packet has been consumed but before the authentication
 	// request, matching the message ordering used by the PostgreSQL backend.
@@ -272,16 +391,89 @@ func (srv *Server) serve(ctx context.Context, conn net.Conn) error {
 		return err
 	}
 
-	ctx, err = srv.handleAuth(ctx, reader, writer)
+	ctx = setProtocolVersion(ctx, min(version, types.VersionLatest))
+
+	// NOTE: the authentication context is cancelled once the authentication
+	// timeout expires allowing strategies to abort early. The cancellation is
+	// dropped once the client has been authenticated.
+	authCtx, cancel := authDeadline.context(ctx)
+	ctx, err = srv.handleAuth(authCtx, reader, writer)
+	cancel()
 	if err != nil {
 		return err
 	}
 
+	ctx = context.WithoutCancel(ctx)
+
+	if deadline.err != nil {
+		deadline, err = setConnDeadline(conn)
+		if err != nil {
+			return err
+		}
+	}
+
+	ctx, err = srv.resolveRole(ctx)
+	if err != nil {
+		return writeAuthError(writer, err)
+	}
+
+	release, err := srv.connections.acquire(ctx)
+	if err != nil {
+		return writeAuthError(writer, err)
+	}
+
+	defer release()
+
+	// NOTE: the process ID returned by the BackendKeyDataFunc is used to
+	// identify the session whenever configured.
+	var processID int32
+	var secretKey []byte
+	if srv.BackendKeyData != nil {
+		processID, secretKey = srv.BackendKeyData(ctx)
+	} else {
+		processID = srv.sessions.nextPID()
+	}
+
+	session := &Session{
+		Server:           srv,
+		Statements:       srv.Statements(),
+		Portals:          srv.Portals(),
+		Attributes:       make(map[string]interface{}),
+		ParallelPipeline: srv.ParallelPipeline,
+		roles:            newRoleState(AuthenticatedRole(ctx)),
+		host:             MatchedVirtualHost(ctx),
+		activity:         newSessionActivity(ctx, processID, started),
+		control:          newSessionControl(conn, secretKey),
+	}
+
+	if srv.ParallelPipeline.Enabled {
+		session.ResponseQueue = NewResponseQueue()
+	}
+
+	// NOTE: the session is registered while holding closingMu for reading,
+	// ensuring that sessions are either rejected or shut down once the server
+	// is shutting down.
+	srv.closingMu.RLock()
+	if srv.closing.Load() {
+		srv.closingMu.RUnlock()
+		return writeAuthError(writer, NewErrShuttingDown())
+	}
+	deregister := srv.sessions.register(session)
+	srv.closingMu.RUnlock()
+
+	defer deregister()
+
+	// NOTE: messages written from this point onwards are buffered, pending
+	// messages are flushed before the connection is closed.
+	if srv.FlushThreshold >= 0 {
+		writer.SetFlushThreshold(srv.FlushThreshold)
+		defer writer.Flush() //nolint:errcheck
+	}
+
 	// Send BackendKeyData if a BackendKeyDataFunc is configured
 	if srv.BackendKeyData != nil {
 		srv.logger.Debug("sending backend key data")
-		processID, secretKey := srv.BackendKeyData(ctx)
-		err = writeBackendKeyData(writer, processID, secretKey)
+		err = writeBackendKeyData(writer, ProtocolVersion(ctx), processID, secretKey)
 		if err != nil {
 			return err
 		}
@@ -289,7 +481,7 @@ func (srv *Server) serve(ctx context.Context, conn net.Conn) error {
 
 	srv.logger.Debug("connection authenticated, writing server parameters")
 
-	ctx, err = srv.writeParameters(ctx, writer, srv.Parameters)
+	ctx, err = srv.writeParameters(ctx, writer, srv.parameters(ctx))
 	if err != nil {
 		return err
 	}
@@ -307,18 +499,6 @@ func (srv *Server) serve(ctx context.Context, conn net.Conn) error {
 		}()
 	}
 
-	session := &Session{
-		Server:           srv,
-		Statements:       srv.Statements(),
-		Portals:          srv.Portals(),
-		Attributes:       make(map[string]interface{}),
-		ParallelPipeline: srv.ParallelPipeline,
-	}
-
-	if srv.ParallelPipeline.Enabled {
-		session.ResponseQueue = NewResponseQueue()
-	}
-
 	ctx = context.WithValue(ctx, sessionKey, session)
 
 	return session.consumeCommands(ctx, conn, reader, writer)
@@ -340,9 +520,12 @@ func (srv *Server) Close() error {
 }
 
 // Shutdown gracefully shuts down the server with context and timeout support.
-// It stops accepting new connections and waits for active connections to finish
-// within the shorter of the context deadline or the server's configured ShutdownTimeout.
-// If the context has no deadline, the server's ShutdownTimeout is used.
+// It stops accepting new connections and shuts down the active sessions using
+// the configured [ShutdownMode]. Shutdown waits for the sessions to be closed
+// within the shorter of the context deadline or the server's configured
+// ShutdownTimeout. If the context has no deadline, the server's
+// ShutdownTimeout is used. Sessions which have not been closed once the
+// shutdown times out are terminated before the context error is returned.
 func (srv *Server) Shutdown(ctx context.Context) error {
 	// Check if already shutting down or shut down
 	srv.closingMu.Lock()
@@ -352,6 +535,7 @@ func (srv *Server) Shutdown(ctx context.Context) error {
 		srv.wg.Wait()
 		return nil
 	}
+	srv.draining.Store(srv.ShutdownMode == ShutdownSmart)
 	srv.closingMu.Unlock()
 
 	// Use the shorter of context deadline or server timeout
@@ -369,15 +553,17 @@ func (srv *Server) Shutdown(ctx context.Context) error {
 	}
 	defer cancel()
 
-	srv.logger.Info("starting graceful shutdown")
+	srv.logger.Info("starting graceful shutdown", slog.String("mode", srv.ShutdownMode.String()))
 
 	// Close the closer channel (we're the first/only one to get here)
 	close(srv.closer)
+	srv.shutdownSessions()
 
-	// Wait for active connections to finish or timeout
+	// Wait for active connections and sessions to finish or timeout
 	done := make(chan struct{})
 	go func() {
 		srv.wg.Wait()
+		srv.sessions.wg.Wait()
 		close(done)
 	}()
 
@@ -386,7 +572,8 @@ func (srv *Server) Shutdown(ctx context.Context) error {
 		srv.logger.Info("graceful shutdown completed")
 		return nil
 	case <-shutdownCtx.Done():
-		srv.logger.Warn("graceful shutdown timed out, some connections may be forcefully closed")
+		srv.logger.Warn("graceful shutdown timed out, terminating the remaining sessions")
+		srv.escalateShutdown(done)
 		return shutdownCtx.Err()
 	}
 }
@@ -3,17 +3,21 @@ package wire
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
 	"io"
 	"log/slog"
 	"net"
 	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgconn"
 	"github.com/jackc/pgx/v5/pgtype"
 	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jeroenrinzema/psql-wire/codes"
 	"github.com/jeroenrinzema/psql-wire/pkg/mock"
 	"github.com/jeroenrinzema/psql-wire/pkg/types"
 	"github.com/lib/pq"
@@ -26,7 +30,7 @@ import (
 // the local network. The newly created listener is passed to the given server to
 // start serving PostgreSQL connections. The full listener address is returned
 // for clients to interact with the newly created server.
-func TListenAndServe(t *testing.T, server *Server) *net.TCPAddr {
+func TListenAndServe(t testing.TB, server *Server) *net.TCPAddr {
 	listener, err := net.Listen("tcp", "127.0.0.1:0")
 	if err != nil {
 		t.Fatal(err)
@@ -1111,6 +1115,229 @@ func TestServerShutdownInfiniteTimeoutWithActiveConnection(t *testing.T) {
 	}
 }
 
+func TestServerShutdownModes(t *testing.T) {
+	t.Parallel()
+
+	type hooks struct {
+		closed     atomic.Int32
+		terminated atomic.Int32
+	}
+
+	setup := func(t *testing.T, mode ShutdownMode) (*Server, *hooks, string, chan struct{}) {
+		started := make(chan struct{}, 1)
+		handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+			statement := NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+				if query.Query == "SELECT pg_sleep(60)" {
+					started <- struct{}{}
+					<-ctx.Done()
+					return ctx.Err()
+				}
+				return writer.Complete("OK")
+			})
+			return Prepared(statement), nil
+		}
+
+		calls := &hooks{}
+		server, err := NewServer(handler,
+			Logger(slogt.New(t)),
+			WithShutdownMode(mode),
+			WithShutdownTimeout(5*time.Second),
+			CloseConn(func(ctx context.Context) error {
+				calls.closed.Add(1)
+				return nil
+			}),
+			TerminateConn(func(ctx context.Context) error {
+				calls.terminated.Add(1)
+				return nil
+			}),
+		)
+		require.NoError(t, err)
+
+		address := TListenAndServeWithoutCleanup(t, server)
+		return server, calls, fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port), started
+	}
+
+	t.Run("smart", func(t *testing.T) {
+		server, calls, connstr, _ := setup(t, ShutdownSmart)
+
+		conn, err := pgx.Connect(context.Background(), connstr)
+		require.NoError(t, err)
+
+		shutdown := make(chan error, 1)
+		go func() {
+			shutdown <- server.Shutdown(context.Background())
+		}()
+
+		// NOTE: sessions continue to be served until closed by the client
+		require.Eventually(t, server.closing.Load, time.Second, 10*time.Millisecond)
+		_, err = conn.Exec(context.Background(), "SELECT 1")
+		require.NoError(t, err)
+
+		select {
+		case <-shutdown:
+			t.Fatal("smart shutdown completed while a session is active")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		require.NoError(t, conn.Close(context.Background()))
+		require.NoError(t, <-shutdown)
+		assert.Equal(t, int32(1), calls.closed.Load())
+		assert.Equal(t, int32(1), calls.terminated.Load())
+	})
+
+	t.Run("fast", func(t *testing.T) {
+		server, calls, connstr, started := setup(t, ShutdownFast)
+
+		idle, err := pgx.Connect(context.Background(), connstr)
+		require.NoError(t, err)
+		defer idle.Close(context.Background()) //nolint:errcheck
+
+		active, err := pgx.Connect(context.Background(), connstr)
+		require.NoError(t, err)
+		defer active.Close(context.Background()) //nolint:errcheck
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := active.Exec(context.Background(), "SELECT pg_sleep(60)")
+			done <- err
+		}()
+
+		<-started
+		require.NoError(t, server.Shutdown(context.Background()))
+		assert.Equal(t, int32(2), calls.closed.Load())
+		assert.Equal(t, int32(2), calls.terminated.Load())
+
+		var pgErr *pgconn.PgError
+		require.ErrorAs(t, <-done, &pgErr)
+		assert.Equal(t, string(codes.AdminShutdown), pgErr.Code)
+		assert.Equal(t, "FATAL", pgErr.Severity)
+
+		_, err = idle.Exec(context.Background(), "SELECT 1")
+		require.ErrorAs(t, err, &pgErr)
+		assert.Equal(t, string(codes.AdminShutdown), pgErr.Code)
+	})
+
+	t.Run("immediate", func(t *testing.T) {
+		server, calls, connstr, started := setup(t, ShutdownImmediate)
+
+		conn, err := pgx.Connect(context.Background(), connstr)
+		require.NoError(t, err)
+		defer conn.Close(context.Background()) //nolint:errcheck
+
+		done := make(chan error, 1)
+		go func() {
+			_, err := conn.Exec(context.Background(), "SELECT pg_sleep(60)")
+			done <- err
+		}()
+
+		<-started
+		require.NoError(t, server.Shutdown(context.Background()))
+		assert.Equal(t, int32(1), calls.closed.Load())
+		assert.Equal(t, int32(1), calls.terminated.Load())
+
+		err = <-done
+		require.Error(t, err)
+
+		var pgErr *pgconn.PgError
+		assert.False(t, errors.As(err, &pgErr))
+	})
+}
+
+func TestServerShutdownDefaultCompletesQueries(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{}, 1)
+	release := make(chan struct{})
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		statement := NewStatement(func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			started <- struct{}{}
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-release:
+			}
+			return writer.Complete("OK")
+		})
+		return Prepared(statement), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)), WithShutdownTimeout(5*time.Second))
+	require.NoError(t, err)
+
+	address := TListenAndServeWithoutCleanup(t, server)
+	conn, err := pgx.Connect(context.Background(), fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := conn.Exec(context.Background(), "SELECT pg_sleep(1)")
+		done <- err
+	}()
+
+	<-started
+	shutdown := make(chan error, 1)
+	go func() {
+		shutdown <- server.Shutdown(context.Background())
+	}()
+
+	// NOTE: in-flight queries are not canceled by the default shutdown mode
+	require.Eventually(t, server.closing.Load, time.Second, 10*time.Millisecond)
+	select {
+	case err := <-done:
+		t.Fatalf("query completed before being released: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	close(release)
+	require.NoError(t, <-done)
+
+	require.NoError(t, conn.Close(context.Background()))
+	require.NoError(t, <-shutdown)
+}
+
+func TestServerShutdownDefaultTerminatesIdleSessions(t *testing.T) {
+	t.Parallel()
+
+	server, err := NewServer(nil, Logger(slogt.New(t)), WithShutdownTimeout(50*time.Millisecond))
+	require.NoError(t, err)
+
+	address := TListenAndServeWithoutCleanup(t, server)
+	conn, err := net.Dial("tcp", address.String())
+	require.NoError(t, err)
+	defer conn.Close() //nolint:errcheck
+
+	client := mock.NewClient(t, conn)
+	client.Handshake(t)
+	client.Authenticate(t)
+	client.ReadyForQuery(t, types.ServerIdle)
+
+	// NOTE: idle sessions are terminated once the default shutdown times out,
+	// the connection has been closed by the time Shutdown returns.
+	err = server.Shutdown(context.Background())
+	require.ErrorIs(t, err, context.DeadlineExceeded)
+
+	require.NoError(t, conn.SetReadDeadline(time.Now().Add(time.Second)))
+	remaining, err := io.ReadAll(conn)
+	require.NoError(t, err)
+	assert.Contains(t, string(remaining), errAdminShutdown.Error())
+}
+
+func TestWithShutdownModeOption(t *testing.T) {
+	t.Parallel()
+
+	server, err := NewServer(nil)
+	require.NoError(t, err)
+	assert.Equal(t, ShutdownSmart, server.ShutdownMode)
+	assert.Equal(t, (&Server{}).ShutdownMode, server.ShutdownMode)
+
+	server, err = NewServer(nil, WithShutdownMode(ShutdownFast))
+	require.NoError(t, err)
+	assert.Equal(t, ShutdownFast, server.ShutdownMode)
+
+	_, err = NewServer(nil, WithShutdownMode(ShutdownMode(42)))
+	require.Error(t, err)
+}
+
 // testLogHandler captures log messages for testing
 type testLogHandler struct {
 	errorLogs *[]string
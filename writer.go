@@ -43,6 +43,19 @@ type DataWriter interface {
 	CopyIn(format FormatCode) (*CopyReader, error)
 }
 
+// Flusher is implemented by data writers which are able to deliver buffered
+// messages to the client. Messages are flushed automatically at the end of
+// each command, a flush could be requested to deliver rows to the client while
+// the handler is still producing rows.
+//
+//	if flusher, ok := writer.(wire.Flusher); ok {
+//		err := flusher.Flush()
+//	}
+type Flusher interface {
+	// Flush delivers all messages which have been buffered to the client.
+	Flush() error
+}
+
 // ErrDataWritten is returned when an empty result is attempted to be sent to the
 // client while data has already been written.
 var ErrDataWritten = errors.New("data has already been written")
@@ -102,6 +115,13 @@ func (writer *dataWriter) CopyIn(format FormatCode) (*CopyReader, error) {
 	if err != nil {
 		return nil, err
 	}
+
+	// NOTE: the client awaits the CopyInResponse before sending any data
+	err = writer.client.Flush()
+	if err != nil {
+		return nil, err
+	}
+
 	return NewCopyReader(writer.session, writer.reader, writer.client, writer.columns), nil
 }
 
@@ -132,6 +152,15 @@ func (writer *dataWriter) Complete(description string) error {
 	return commandComplete(writer.client, description)
 }
 
+// Flush delivers all messages which have been buffered to the client.
+func (writer *dataWriter) Flush() error {
+	if writer.closed {
+		return ErrClosedWriter
+	}
+
+	return writer.client.Flush()
+}
+
 func (writer *dataWriter) close() {
 	writer.closed = true
 }
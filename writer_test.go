@@ -0,0 +1,126 @@
+package wire
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/jackc/pgx/v5"
+	"github.com/jackc/pgx/v5/pgtype"
+	"github.com/neilotoole/slogt"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestDataWriterFlush(t *testing.T) {
+	t.Parallel()
+
+	release := make(chan struct{})
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			err := writer.Row([]any{int32(1)})
+			if err != nil {
+				return err
+			}
+
+			flusher, ok := writer.(Flusher)
+			if !ok {
+				return errors.New("data writer does not implement flusher")
+			}
+
+			err = flusher.Flush()
+			if err != nil {
+				return err
+			}
+
+			<-release
+			err = writer.Row([]any{int32(2)})
+			if err != nil {
+				return err
+			}
+
+			return writer.Complete("SELECT 2")
+		}
+
+		columns := Columns{{Name: "id", Oid: pgtype.Int4OID, Width: 4}}
+		return Prepared(NewStatement(handle, WithColumns(columns))), nil
+	}
+
+	server, err := NewServer(handler, Logger(slogt.New(t)))
+	require.NoError(t, err)
+
+	address := TListenAndServe(t, server)
+	conn, err := pgx.Connect(context.Background(), fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+	require.NoError(t, err)
+	defer conn.Close(context.Background()) //nolint:errcheck
+
+	rows, err := conn.Query(context.Background(), "SELECT id")
+	require.NoError(t, err)
+	defer rows.Close()
+
+	// NOTE: the first row is delivered while the handler is still producing rows
+	var id int32
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(&id))
+	assert.Equal(t, int32(1), id)
+
+	close(release)
+	require.True(t, rows.Next())
+	require.NoError(t, rows.Scan(&id))
+	assert.Equal(t, int32(2), id)
+
+	assert.False(t, rows.Next())
+	require.NoError(t, rows.Err())
+}
+
+// BenchmarkLargeResultSet benchmarks reading a large result set with and
+// without output buffering.
+func BenchmarkLargeResultSet(b *testing.B) {
+	rows := 10000
+	handler := func(ctx context.Context, query Query) (PreparedStatements, error) {
+		handle := func(ctx context.Context, writer DataWriter, parameters []Parameter) error {
+			for i := range rows {
+				err := writer.Row([]any{int32(i), "John Doe"})
+				if err != nil {
+					return err
+				}
+			}
+
+			return writer.Complete(fmt.Sprintf("SELECT %d", rows))
+		}
+
+		columns := Columns{
+			{Name: "id", Oid: pgtype.Int4OID, Width: 4},
+			{Name: "name", Oid: pgtype.TextOID, Width: 256},
+		}
+
+		return Prepared(NewStatement(handle, WithColumns(columns))), nil
+	}
+
+	run := func(b *testing.B, threshold int) {
+		server, err := NewServer(handler, Logger(slog.New(slog.DiscardHandler)), FlushThreshold(threshold))
+		require.NoError(b, err)
+
+		address := TListenAndServe(b, server)
+		conn, err := pgx.Connect(context.Background(), fmt.Sprintf("postgres://%s:%d?sslmode=disable", address.IP, address.Port))
+		require.NoError(b, err)
+		defer conn.Close(context.Background()) //nolint:errcheck
+
+		b.ReportAllocs()
+		for b.Loop() {
+			result, err := conn.Exec(context.Background(), "SELECT id, name")
+			require.NoError(b, err)
+			require.Equal(b, int64(rows), result.RowsAffected())
+		}
+	}
+
+	b.Run("unbuffered", func(b *testing.B) {
+		run(b, -1)
+	})
+
+	b.Run("buffered", func(b *testing.B) {
+		run(b, 0)
+	})
+}